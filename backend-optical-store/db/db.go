@@ -54,6 +54,14 @@ func ConnectDB() {
 
 	// Create performance indexes only after tables are successfully created
 	CreateCartIndexes()
+
+	// Seed reference data
+	SeedLensOptions()
+	SeedOpeningBalances()
+	BackfillOrderCurrency()
+	BackfillOrderSubtotals()
+	BackfillOrderAddresses()
+	IndexProductsForSearch()
 }
 
 // createDatabaseIfNotExists creates the database if it doesn't exist
@@ -113,6 +121,9 @@ func createTables() {
 	
 	// Clean up any orphaned tablespace files that might exist
 	cleanupOrphanedTablespaces()
+
+	// Rescale float amounts before AutoMigrate changes their column type
+	migrateMoneyColumns()
 	
 	// Auto-migrate tables based on models one by one for better error handling
 	models := []interface{}{
@@ -127,6 +138,15 @@ func createTables() {
 		&models.Order{},
 		&models.OrderItem{},
 		&models.RefreshToken{},
+		&models.LensOption{},
+		&models.StockReservation{},
+		&models.InventoryMovement{},
+		&models.ExchangeRate{},
+		&models.TaxRule{},
+		&models.OrderTax{},
+		&models.Promotion{},
+		&models.PromotionRedemption{},
+		&models.OrderDiscount{},
 	}
 	
 	for _, model := range models {
@@ -160,7 +180,9 @@ func createTables() {
 func cleanupOrphanedTablespaces() {
 	// Get list of table names that might have orphaned tablespaces
 	tableNames := []string{"users", "categories", "products", "variants", "addresses", 
-		"prescriptions", "carts", "cart_items", "orders", "order_items", "refresh_tokens"}
+		"prescriptions", "carts", "cart_items", "orders", "order_items", "refresh_tokens", "lens_options", "stock_reservations",
+		"inventory_movements", "exchange_rates", "tax_rules", "order_taxes",
+		"promotions", "promotion_redemptions", "order_discounts"}
 	
 	for _, tableName := range tableNames {
 		// Check if table exists in information_schema but has tablespace issues
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"log"
+)
+
+// moneyColumns are the amount columns that used to be floating point reais
+// and are now models.Money, a BIGINT of centavos
+var moneyColumns = []struct{ table, column string }{
+	{"products", "base_price"},
+	{"variants", "extra_price"},
+	{"cart_items", "unit_price"},
+	{"cart_items", "lens_price"},
+	{"orders", "total"},
+	{"order_items", "unit_price"},
+	{"order_items", "lens_price"},
+	{"lens_options", "price_delta"},
+}
+
+// migrateMoneyColumns converts floating point amount columns to centavos. It must
+// run before AutoMigrate, which would change the column type without rescaling.
+// Each column is copied into <column>_minor first, so an interrupted run resumes
+// where it stopped instead of scaling an amount twice.
+func migrateMoneyColumns() {
+	for _, c := range moneyColumns {
+		if err := migrateMoneyColumn(c.table, c.column); err != nil {
+			log.Printf("Error converting %s.%s to centavos: %v", c.table, c.column, err)
+		}
+	}
+}
+
+func migrateMoneyColumn(table, column string) error {
+	minor := column + "_minor"
+	oldType, err := columnType(table, column)
+	if err != nil {
+		return err
+	}
+	minorType, err := columnType(table, minor)
+	if err != nil {
+		return err
+	}
+
+	switch oldType {
+	case "double", "float", "decimal":
+		if minorType != "" {
+			// Left over from an interrupted run; the amounts are still in the old column
+			if err := DB.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`", table, minor)).Error; err != nil {
+				return err
+			}
+		}
+	case "":
+		if minorType == "" {
+			// New table or column, AutoMigrate creates it
+			return nil
+		}
+		// Interrupted after dropping the old column
+		return renameMinorColumn(table, column)
+	default:
+		// Already converted
+		return nil
+	}
+
+	log.Printf("Converting %s.%s to centavos", table, column)
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` BIGINT NOT NULL DEFAULT 0", table, minor),
+		fmt.Sprintf("UPDATE `%s` SET `%s` = ROUND(`%s` * 100)", table, minor, column),
+		fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`", table, column),
+	}
+	for _, stmt := range statements {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return renameMinorColumn(table, column)
+}
+
+func renameMinorColumn(table, column string) error {
+	return DB.Exec(fmt.Sprintf("ALTER TABLE `%s` CHANGE `%s_minor` `%s` BIGINT NOT NULL DEFAULT 0", table, column, column)).Error
+}
+
+// columnType returns the lower-case data type of a column, or "" when the table
+// or column does not exist
+func columnType(table, column string) (string, error) {
+	var dataTypes []string
+	err := DB.Raw("SELECT LOWER(DATA_TYPE) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		table, column).Scan(&dataTypes).Error
+	if err != nil || len(dataTypes) == 0 {
+		return "", err
+	}
+	return dataTypes[0], nil
+}
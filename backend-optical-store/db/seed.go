@@ -0,0 +1,139 @@
+package db
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+
+	"backend-optical-store/models"
+	"backend-optical-store/search"
+)
+
+// defaultLensOptions is the starting lens price list, editable in the lens_options table
+var defaultLensOptions = []models.LensOption{
+	{Kind: models.LensKindType, Code: "single_vision", Name: "Single vision", PriceDelta: 150_00},
+	{Kind: models.LensKindType, Code: "bifocal", Name: "Bifocal", PriceDelta: 350_00},
+	{Kind: models.LensKindType, Code: "progressive", Name: "Progressive", PriceDelta: 600_00},
+	{Kind: models.LensKindIndex, Code: "1.50", Name: "Standard 1.50", PriceDelta: 0},
+	{Kind: models.LensKindIndex, Code: "1.60", Name: "Thin 1.60", PriceDelta: 120_00},
+	{Kind: models.LensKindIndex, Code: "1.67", Name: "Extra thin 1.67", PriceDelta: 250_00},
+	{Kind: models.LensKindIndex, Code: "1.74", Name: "Ultra thin 1.74", PriceDelta: 450_00},
+	{Kind: models.LensKindCoating, Code: "anti_reflective", Name: "Anti-reflective", PriceDelta: 120_00},
+	{Kind: models.LensKindCoating, Code: "blue_light", Name: "Blue light filter", PriceDelta: 150_00},
+	{Kind: models.LensKindCoating, Code: "photochromic", Name: "Photochromic", PriceDelta: 300_00},
+}
+
+// SeedLensOptions fills the lens price list on first start
+func SeedLensOptions() {
+	var count int64
+	if err := DB.Model(&models.LensOption{}).Count(&count).Error; err != nil {
+		log.Printf("Error checking lens options: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	options := defaultLensOptions
+	if err := DB.Create(&options).Error; err != nil {
+		log.Printf("Error seeding lens options: %v", err)
+		return
+	}
+	log.Printf("Seeded %d lens options", len(options))
+}
+
+// SeedOpeningBalances books the current stock of variants that predate the
+// inventory ledger as an opening adjustment, so the ledger sums match StockQty
+func SeedOpeningBalances() {
+	var variants []models.Variant
+	err := DB.Where("stock_qty <> 0").
+		Where("NOT EXISTS (SELECT 1 FROM inventory_movements WHERE inventory_movements.variant_id = variants.id)").
+		Find(&variants).Error
+	if err != nil {
+		log.Printf("Error checking opening balances: %v", err)
+		return
+	}
+	if len(variants) == 0 {
+		return
+	}
+
+	movements := make([]models.InventoryMovement, 0, len(variants))
+	for _, v := range variants {
+		movements = append(movements, models.InventoryMovement{
+			VariantID: v.ID,
+			Kind:      models.MovementAdjustment,
+			QtyDelta:  v.StockQty,
+			Reason:    "opening balance",
+		})
+	}
+	if err := DB.Create(&movements).Error; err != nil {
+		log.Printf("Error seeding opening balances: %v", err)
+		return
+	}
+	log.Printf("Booked opening balances for %d variants", len(movements))
+}
+
+// BackfillOrderCurrency records orders placed before multi-currency pricing as
+// charged in the base currency at parity
+func BackfillOrderCurrency() {
+	result := DB.Model(&models.Order{}).
+		Where("exchange_rate = 0").
+		Updates(map[string]interface{}{
+			"currency":      models.Currency,
+			"exchange_rate": models.RateScale,
+			"charged_total": gorm.Expr("total"),
+		})
+	if result.Error != nil {
+		log.Printf("Error backfilling order currencies: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Recorded base currency charges for %d orders", result.RowsAffected)
+	}
+}
+
+// BackfillOrderSubtotals sets the subtotal of orders placed before taxes, whose
+// total is the sum of their lines
+func BackfillOrderSubtotals() {
+	result := DB.Model(&models.Order{}).
+		Where("subtotal = 0 AND tax_total = 0 AND total <> 0").
+		Update("subtotal", gorm.Expr("total"))
+	if result.Error != nil {
+		log.Printf("Error backfilling order subtotals: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Set subtotals for %d orders", result.RowsAffected)
+	}
+}
+
+// BackfillOrderAddresses copies the shipping address onto orders placed before
+// addresses were recorded on the order, while the address still exists
+func BackfillOrderAddresses() {
+	result := DB.Exec(`UPDATE orders JOIN addresses ON addresses.id = orders.address_id
+		SET orders.shipping_name = addresses.name, orders.shipping_line1 = addresses.line1,
+			orders.shipping_line2 = COALESCE(addresses.line2, ''), orders.shipping_city = addresses.city,
+			orders.shipping_state = addresses.state, orders.shipping_postal_code = addresses.postal_code,
+			orders.shipping_country = addresses.country
+		WHERE orders.shipping_country = '' OR orders.shipping_country IS NULL`)
+	if result.Error != nil {
+		log.Printf("Error backfilling order addresses: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Recorded shipping addresses for %d orders", result.RowsAffected)
+	}
+}
+
+// IndexProductsForSearch builds search documents for products that have none,
+// such as products created before search was introduced
+func IndexProductsForSearch() {
+	count, err := search.RefreshMissing(DB)
+	if err != nil {
+		log.Printf("Error building search documents: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Built search documents for %d products", count)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// isoCountries holds every officially assigned ISO 3166-1 alpha-2 code
+var isoCountries = map[string]bool{}
+
+func init() {
+	for _, code := range strings.Fields(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ
+		BR BS BT BV BW BY BZ CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM
+		DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS
+		GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN
+		KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ
+		MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM
+		PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV
+		SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI
+		VN VU WF WS YE YT ZA ZM ZW`) {
+		isoCountries[code] = true
+	}
+}
+
+// postalCodeFormats are the postal code patterns for countries we ship to most
+var postalCodeFormats = map[string]*regexp.Regexp{
+	"AR": regexp.MustCompile(`^([A-Z]\d{4}[A-Z]{3}|\d{4})$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CL": regexp.MustCompile(`^\d{7}$`),
+	"CO": regexp.MustCompile(`^\d{6}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"IN": regexp.MustCompile(`^\d{6}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"MX": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"PE": regexp.MustCompile(`^\d{5}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"PY": regexp.MustCompile(`^\d{4}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"UY": regexp.MustCompile(`^\d{5}$`),
+}
+
+// genericPostalCode is accepted for countries without a specific pattern
+var genericPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`)
+
+// noPostalCodeCountries don't use postal codes, so an empty one is valid there
+var noPostalCodeCountries = map[string]bool{
+	"AE": true, "AG": true, "AO": true, "AW": true, "BS": true, "BZ": true, "CW": true, "DJ": true,
+	"DM": true, "ER": true, "FJ": true, "GD": true, "GM": true, "GY": true, "HK": true, "KI": true,
+	"KM": true, "KN": true, "LC": true, "MO": true, "QA": true, "SB": true, "SC": true, "SR": true,
+	"SX": true, "TG": true, "TK": true, "TL": true, "TO": true, "TV": true, "UG": true, "VU": true,
+	"YE": true, "ZW": true,
+}
+
+// normalizeAddress trims and upper-cases the fields we validate, then checks them
+func normalizeAddress(req *addressRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Line1 = strings.TrimSpace(req.Line1)
+	req.City = strings.TrimSpace(req.City)
+	req.State = strings.TrimSpace(req.State)
+	req.Country = strings.ToUpper(strings.TrimSpace(req.Country))
+	req.PostalCode = strings.ToUpper(strings.TrimSpace(req.PostalCode))
+	if req.Line2 != nil {
+		if line2 := strings.TrimSpace(*req.Line2); line2 != "" {
+			req.Line2 = &line2
+		} else {
+			req.Line2 = nil
+		}
+	}
+
+	if req.Name == "" || req.Line1 == "" || req.City == "" {
+		return fmt.Errorf("name, line1 and city are required")
+	}
+	if !isoCountries[req.Country] {
+		return fmt.Errorf("country must be an ISO 3166-1 alpha-2 code")
+	}
+
+	if req.PostalCode == "" {
+		if noPostalCodeCountries[req.Country] {
+			return nil
+		}
+		return fmt.Errorf("postal_code is required for %s", req.Country)
+	}
+	format, ok := postalCodeFormats[req.Country]
+	if !ok {
+		format = genericPostalCode
+	}
+	if !format.MatchString(req.PostalCode) {
+		return fmt.Errorf("postal_code is not valid for %s", req.Country)
+	}
+	return nil
+}
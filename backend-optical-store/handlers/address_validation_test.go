@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		country, postalCode string
+		wantCountry         string
+		wantPostalCode      string
+		want                string // part of the error, "" when valid
+	}{
+		// Country codes
+		{"BR", "01310-100", "BR", "01310-100", ""},
+		{" br ", "01310100", "BR", "01310100", ""},
+		{"Us", "94105", "US", "94105", ""},
+		{"BRA", "01310-100", "", "", "ISO 3166-1"},
+		{"XX", "12345", "", "", "ISO 3166-1"},
+		{"UK", "SW1A 1AA", "", "", "ISO 3166-1"}, // the ISO code is GB
+		{"", "12345", "", "", "ISO 3166-1"},
+
+		// Per-country postal formats
+		{"BR", "0131-0100", "", "", "not valid for BR"},
+		{"US", "94105-1234", "US", "94105-1234", ""},
+		{"US", "9410", "", "", "not valid for US"},
+		{"CA", "k1a 0b1", "CA", "K1A 0B1", ""},
+		{"CA", "K1A0B1", "CA", "K1A0B1", ""},
+		{"CA", "12345", "", "", "not valid for CA"},
+		{"GB", "sw1a 1aa", "GB", "SW1A 1AA", ""},
+		{"GB", "M1 1AE", "GB", "M1 1AE", ""},
+		{"GB", "12345", "", "", "not valid for GB"},
+		{"NL", "1012 ab", "NL", "1012 AB", ""},
+		{"PT", "1000-001", "PT", "1000-001", ""},
+		{"PT", "1000001", "", "", "not valid for PT"},
+		{"JP", "100-0001", "JP", "100-0001", ""},
+		{"AR", "C1000AAA", "AR", "C1000AAA", ""},
+		{"AR", "1000", "AR", "1000", ""},
+		{"DE", "1011", "", "", "not valid for DE"},
+
+		// Countries without a specific pattern, or without postal codes
+		{"SE", "114 55", "SE", "114 55", ""},
+		{"SE", "114_55", "", "", "not valid for SE"},
+		{"HK", "", "HK", "", ""},
+		{"HK", "999077", "HK", "999077", ""},
+		{"US", "", "", "", "postal_code is required for US"},
+		{"US", "   ", "", "", "postal_code is required for US"},
+	}
+	for _, tt := range tests {
+		req := addressRequest{Name: " Ana ", Line1: "Av. Paulista, 1000", City: "São Paulo", Country: tt.country, PostalCode: tt.postalCode}
+		err := normalizeAddress(&req)
+		switch {
+		case tt.want != "":
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("normalizeAddress(%q, %q) = %v, want %q", tt.country, tt.postalCode, err, tt.want)
+			}
+		case err != nil:
+			t.Errorf("normalizeAddress(%q, %q) = %v, want nil", tt.country, tt.postalCode, err)
+		case req.Country != tt.wantCountry || req.PostalCode != tt.wantPostalCode:
+			t.Errorf("normalizeAddress(%q, %q) gave %q, %q; want %q, %q",
+				tt.country, tt.postalCode, req.Country, req.PostalCode, tt.wantCountry, tt.wantPostalCode)
+		}
+	}
+}
+
+func TestNormalizeAddressFields(t *testing.T) {
+	blank, line2 := "  ", " Apt 4 "
+	req := addressRequest{Name: " Ana ", Line1: " Rua A ", Line2: &blank, City: " Lisboa ", Country: "pt", PostalCode: "1000-001"}
+	if err := normalizeAddress(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Name != "Ana" || req.Line1 != "Rua A" || req.City != "Lisboa" || req.Line2 != nil {
+		t.Errorf("fields not trimmed: %+v", req)
+	}
+
+	req.Line2 = &line2
+	if err := normalizeAddress(&req); err != nil || req.Line2 == nil || *req.Line2 != "Apt 4" {
+		t.Errorf("line2 = %v, %v; want Apt 4", req.Line2, err)
+	}
+
+	for _, missing := range []addressRequest{
+		{Line1: "Rua A", City: "Lisboa", Country: "PT", PostalCode: "1000-001"},
+		{Name: "Ana", Line1: " ", City: "Lisboa", Country: "PT", PostalCode: "1000-001"},
+		{Name: "Ana", Line1: "Rua A", Country: "PT", PostalCode: "1000-001"},
+	} {
+		if err := normalizeAddress(&missing); err == nil {
+			t.Errorf("normalizeAddress(%+v) accepted a missing field", missing)
+		}
+	}
+}
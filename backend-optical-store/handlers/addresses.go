@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type addressRequest struct {
+	Name       string  `json:"name"`
+	Line1      string  `json:"line1"`
+	Line2      *string `json:"line2,omitempty"`
+	City       string  `json:"city"`
+	State      string  `json:"state"`
+	PostalCode string  `json:"postal_code"`
+	Country    string  `json:"country"`
+	IsDefault  bool    `json:"is_default"`
+}
+
+// GetAddresses lists the authenticated user's addresses, default first
+func GetAddresses(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		addresses := []models.Address{}
+		if err := db.Where("user_id = ?", userID).Order("is_default DESC, id").Find(&addresses).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(addresses)
+	}
+}
+
+// CreateAddress adds an address to the user's address book
+func CreateAddress(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		var req addressRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := normalizeAddress(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		address := models.Address{UserID: userID}
+		applyAddressRequest(&address, req)
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAddressBook(tx, userID); err != nil {
+				return err
+			}
+
+			// The first address always becomes the default
+			var count int64
+			if err := tx.Model(&models.Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				address.IsDefault = true
+			}
+
+			if address.IsDefault {
+				if err := clearDefaultAddress(tx, userID); err != nil {
+					return err
+				}
+			}
+			return tx.Create(&address).Error
+		})
+		if err != nil {
+			http.Error(w, "Failed to create address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(address)
+	}
+}
+
+// UpdateAddress replaces one of the user's addresses
+func UpdateAddress(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		addressID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid address ID", http.StatusBadRequest)
+			return
+		}
+
+		var req addressRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := normalizeAddress(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var address models.Address
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAddressBook(tx, userID); err != nil {
+				return err
+			}
+			if err := tx.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
+				return err
+			}
+
+			// Unsetting the default is done by making another address the default
+			wasDefault := address.IsDefault
+			applyAddressRequest(&address, req)
+			address.IsDefault = address.IsDefault || wasDefault
+
+			if address.IsDefault && !wasDefault {
+				if err := clearDefaultAddress(tx, userID); err != nil {
+					return err
+				}
+			}
+			return tx.Save(&address).Error
+		})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Address not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to update address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(address)
+	}
+}
+
+// DeleteAddress removes one of the user's addresses, handing the default to the newest remaining one
+func DeleteAddress(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		addressID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid address ID", http.StatusBadRequest)
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := lockAddressBook(tx, userID); err != nil {
+				return err
+			}
+
+			var address models.Address
+			if err := tx.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&address).Error; err != nil {
+				return err
+			}
+			if !address.IsDefault {
+				return nil
+			}
+
+			var next models.Address
+			err := tx.Where("user_id = ?", userID).Order("id DESC").First(&next).Error
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			return tx.Model(&next).Update("is_default", true).Error
+		})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Address not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete address", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// lockAddressBook takes a row lock on the user so concurrent address changes are serialized,
+// which keeps at most one default address per user
+func lockAddressBook(tx *gorm.DB, userID int64) error {
+	var user models.User
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, userID).Error
+}
+
+// clearDefaultAddress unsets the user's current default address
+func clearDefaultAddress(tx *gorm.DB, userID int64) error {
+	return tx.Model(&models.Address{}).
+		Where("user_id = ? AND is_default = ?", userID, true).
+		Update("is_default", false).Error
+}
+
+func applyAddressRequest(address *models.Address, req addressRequest) {
+	address.Name = req.Name
+	address.Line1 = req.Line1
+	address.Line2 = req.Line2
+	address.City = req.City
+	address.State = req.State
+	address.PostalCode = req.PostalCode
+	address.Country = req.Country
+	address.IsDefault = req.IsDefault
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -22,7 +24,10 @@ type loginRequest struct {
 type registerRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	Role     string `json:"role"`
+}
+
+type updateRoleRequest struct {
+	Role string `json:"role"`
 }
 
 type updateProfileRequest struct {
@@ -72,11 +77,11 @@ func Register(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		// Create user
+		// Create user; self-registered accounts are always customers
 		user := models.User{
 			Email:        req.Email,
 			PasswordHash: string(hashedPassword),
-			Role:         req.Role,
+			Role:         models.RoleCustomer,
 		}
 
 		if err := db.Create(&user).Error; err != nil {
@@ -85,7 +90,7 @@ func Register(db *gorm.DB) http.HandlerFunc {
 		}
 
 		// Generate tokens
-		tokens, err := generateTokens(user.ID)
+		tokens, err := generateTokens(user.ID, user.Role)
 		if err != nil {
 			http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
 			return
@@ -122,7 +127,7 @@ func Login(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		tokens, err := generateTokens(user.ID)
+		tokens, err := generateTokens(user.ID, user.Role)
 		if err != nil {
 			http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
 			return
@@ -149,13 +154,7 @@ func RefreshToken(db *gorm.DB) http.HandlerFunc {
 		}
 
 		// Validate refresh token
-		token, err := jwt.Parse(req.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(middleware.GetJWTSecret()), nil
-		})
-
+		token, err := middleware.ParseToken(req.RefreshToken)
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 			return
@@ -176,8 +175,15 @@ func RefreshToken(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		// Reload the user so role changes since the last login are picked up
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
 		// Generate new tokens
-		tokens, err := generateTokens(userID)
+		tokens, err := generateTokens(userID, user.Role)
 		if err != nil {
 			http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
 			return
@@ -276,25 +282,60 @@ func DeleteProfile(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-func generateTokens(userID int64) (*tokenResponse, error) {
+// UpdateUserRole lets an admin promote or demote another user
+func UpdateUserRole(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var req updateRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Role != models.RoleCustomer && req.Role != models.RoleOptician && req.Role != models.RoleAdmin {
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		// The new role takes effect on the user's next login or token refresh
+		user.Role = req.Role
+		if err := db.Save(&user).Error; err != nil {
+			http.Error(w, "Failed to update role", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+func generateTokens(userID int64, role string) (*tokenResponse, error) {
 	// Generate access token
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	accessTokenString, err := middleware.SignToken(jwt.MapClaims{
 		"user_id": userID,
+		"role":    role,
 		"exp":     time.Now().Add(15 * time.Minute).Unix(),
 	})
-
-	accessTokenString, err := accessToken.SignedString([]byte(middleware.GetJWTSecret()))
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate refresh token
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	refreshTokenString, err := middleware.SignToken(jwt.MapClaims{
 		"user_id": userID,
 		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(),
 	})
-
-	refreshTokenString, err := refreshToken.SignedString([]byte(middleware.GetJWTSecret()))
 	if err != nil {
 		return nil, err
 	}
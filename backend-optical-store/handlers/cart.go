@@ -4,6 +4,7 @@ import (
 	"backend-optical-store/middleware"
 	"backend-optical-store/models"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -14,45 +15,60 @@ import (
 
 // Cart response structures
 type CartResponse struct {
-	ID         int64          `json:"id"`
-	UserID     int64          `json:"user_id"`
-	Status     string         `json:"status"`
-	Items      []CartItemResp `json:"items"`
-	TotalItems int            `json:"total_items"`
-	TotalPrice float64        `json:"total_price"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
+	ID            int64                 `json:"id"`
+	UserID        int64                 `json:"user_id"`
+	Status        string                `json:"status"`
+	Items         []CartItemResp        `json:"items"`
+	TotalItems    int                   `json:"total_items"`
+	TotalPrice    models.Money          `json:"total_price"` // same as Subtotal, kept for existing clients
+	Subtotal      models.Money          `json:"subtotal"`
+	Taxes         []models.TaxLine      `json:"taxes"`
+	TaxTotal      models.Money          `json:"tax_total"` // including taxes contained in the prices
+	Discounts     []models.DiscountLine `json:"discounts"`
+	DiscountTotal models.Money          `json:"discount_total"`
+	Shipping      models.Money          `json:"shipping"`
+	CouponCode    string                `json:"coupon_code,omitempty"`
+	CouponError   string                `json:"coupon_error,omitempty"` // why the coupon gives no discount
+	GrandTotal    models.Money          `json:"grand_total"`            // subtotal less discounts, plus shipping and the taxes added on top
+	AddressID     *int64                `json:"address_id"`             // address the taxes are for; null without a default address
+	Currency      string                `json:"currency"`
+	CreatedAt     time.Time             `json:"created_at"`
+	UpdatedAt     time.Time             `json:"updated_at"`
 }
 
 type CartItemResp struct {
-	ID               int64       `json:"id"`
-	CartID           int64       `json:"cart_id"`
-	ProductVariantID int64       `json:"product_variant_id"`
-	Qty              int         `json:"qty"`
-	UnitPrice        float64     `json:"unit_price"`
-	Variant          CartVariant `json:"variant"`
+	ID               int64               `json:"id"`
+	CartID           int64               `json:"cart_id"`
+	ProductVariantID int64               `json:"product_variant_id"`
+	Qty              int                 `json:"qty"`
+	UnitPrice        models.Money        `json:"unit_price"`
+	LensOptions      *models.LensOptions `json:"lens_options,omitempty"`
+	LensPrice        models.Money        `json:"lens_price"`
+	Variant          CartVariant         `json:"variant"`
 }
 
 type CartVariant struct {
-	ID         int64       `json:"id"`
-	SKU        string      `json:"sku"`
-	Color      string      `json:"color"`
-	Size       string      `json:"size"`
-	StockQty   int         `json:"stock_qty"`
-	ExtraPrice float64     `json:"extra_price"`
-	Product    CartProduct `json:"product"`
+	ID         int64        `json:"id"`
+	SKU        string       `json:"sku"`
+	Color      string       `json:"color"`
+	Size       string       `json:"size"`
+	StockQty   int          `json:"stock_qty"`
+	ExtraPrice models.Money `json:"extra_price"`
+	Product    CartProduct  `json:"product"`
 }
 
 type CartProduct struct {
-	ID        int64   `json:"id"`
-	Name      string  `json:"name"`
-	Image     string  `json:"image"`
-	BasePrice float64 `json:"base_price"`
+	ID            int64        `json:"id"`
+	Name          string       `json:"name"`
+	Image         string       `json:"image"`
+	BasePrice     models.Money `json:"base_price"`
+	AcceptsLenses bool         `json:"accepts_lenses"`
 }
 
 type AddToCartRequest struct {
-	ProductVariantID int64 `json:"product_variant_id"`
-	Quantity         int   `json:"quantity"`
+	ProductVariantID int64               `json:"product_variant_id"`
+	Quantity         int                 `json:"quantity"`
+	LensOptions      *models.LensOptions `json:"lens_options,omitempty"`
 }
 
 type UpdateCartItemRequest struct {
@@ -69,9 +85,20 @@ func GetCart(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		currency, err := requestCurrency(db, r)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
+		}
+		address, err := requestAddress(db, r, userID)
+		if err != nil {
+			writeAddressError(w, err)
+			return
+		}
+
 		// Find or create active cart for user
 		var cart models.Cart
-		err := db.Where("user_id = ? AND status = ?", userID, "active").First(&cart).Error
+		err = db.Where("user_id = ? AND status = ?", userID, "active").First(&cart).Error
 		if err != nil {
 			if err == gorm.ErrRecordNotFound {
 				// Create new active cart
@@ -100,7 +127,11 @@ func GetCart(db *gorm.DB) http.HandlerFunc {
 		}
 
 		// Build response
-		response := buildCartResponse(cart, cartItems)
+		response, err := buildCartResponse(db, cart, cartItems, currency, address)
+		if err != nil {
+			http.Error(w, "Failed to calculate cart totals", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
@@ -117,6 +148,17 @@ func AddToCart(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		currency, err := requestCurrency(db, r)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
+		}
+		address, err := requestAddress(db, r, userID)
+		if err != nil {
+			writeAddressError(w, err)
+			return
+		}
+
 		// Parse request body
 		var req AddToCartRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -132,7 +174,7 @@ func AddToCart(db *gorm.DB) http.HandlerFunc {
 
 		// Check if variant exists and has sufficient stock
 		var variant models.Variant
-		err := db.Preload("Product").First(&variant, req.ProductVariantID).Error
+		err = db.Preload("Product").First(&variant, req.ProductVariantID).Error
 		if err != nil {
 			if err == gorm.ErrRecordNotFound {
 				http.Error(w, "Product variant not found", http.StatusNotFound)
@@ -142,6 +184,18 @@ func AddToCart(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		// Validate and price the lens configuration against the frame; this also
+		// normalizes it, so identical configurations merge into one cart line
+		lensPrice, err := priceLensOptions(db, variant, req.LensOptions)
+		if err != nil {
+			if errors.Is(err, errInvalidLensOptions) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
 		// Find or create active cart
 		var cart models.Cart
 		err = db.Where("user_id = ? AND status = ?", userID, "active").First(&cart).Error
@@ -163,29 +217,45 @@ func AddToCart(db *gorm.DB) http.HandlerFunc {
 			}
 		}
 
-		// Check if item already exists in cart
+		// Check if the same frame with the same lenses is already in the cart
 		var existingItem models.CartItem
-		err = db.Where("cart_id = ? AND product_variant_id = ?", cart.ID, req.ProductVariantID).First(&existingItem).Error
+		itemQuery := db.Where("cart_id = ? AND product_variant_id = ?", cart.ID, req.ProductVariantID)
+		if req.LensOptions == nil {
+			itemQuery = itemQuery.Where("lens_options_json IS NULL")
+		} else {
+			lensJSON, _ := json.Marshal(req.LensOptions)
+			itemQuery = itemQuery.Where("lens_options_json = ?", string(lensJSON))
+		}
+		err = itemQuery.First(&existingItem).Error
 
-		totalQuantity := req.Quantity
-		if err == nil {
-			// Item exists, calculate total quantity
-			totalQuantity += existingItem.Qty
+		// Stock is per frame, so count every line holding this variant
+		var inCart int64
+		if err := db.Model(&models.CartItem{}).Where("cart_id = ? AND product_variant_id = ?", cart.ID, req.ProductVariantID).
+			Select("COALESCE(SUM(qty), 0)").Scan(&inCart).Error; err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
 		}
+		totalQuantity := req.Quantity + int(inCart)
 
-		// Check stock availability
-		if totalQuantity > variant.StockQty {
+		// Check stock availability, leaving out what other carts hold at checkout
+		available, stockErr := availableStock(db, variant, cart.ID)
+		if stockErr != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if totalQuantity > available {
 			http.Error(w, "Insufficient stock available", http.StatusBadRequest)
 			return
 		}
 
 		// Calculate unit price
-		unitPrice := variant.Product.BasePrice + variant.ExtraPrice
+		unitPrice := variant.Product.BasePrice + variant.ExtraPrice + lensPrice
 
 		if err == nil {
 			// Update existing item
-			existingItem.Qty = totalQuantity
+			existingItem.Qty += req.Quantity
 			existingItem.UnitPrice = unitPrice // Update price in case it changed
+			existingItem.LensPrice = lensPrice
 			if err := db.Save(&existingItem).Error; err != nil {
 				http.Error(w, "Failed to update cart item", http.StatusInternalServerError)
 				return
@@ -197,6 +267,8 @@ func AddToCart(db *gorm.DB) http.HandlerFunc {
 				ProductVariantID: req.ProductVariantID,
 				Qty:              req.Quantity,
 				UnitPrice:        unitPrice,
+				LensOptions:      req.LensOptions,
+				LensPrice:        lensPrice,
 			}
 			if err := db.Create(&cartItem).Error; err != nil {
 				http.Error(w, "Failed to add item to cart", http.StatusInternalServerError)
@@ -214,7 +286,11 @@ func AddToCart(db *gorm.DB) http.HandlerFunc {
 		// Return updated cart
 		var cartItems []models.CartItem
 		db.Preload("Variant.Product").Where("cart_id = ?", cart.ID).Find(&cartItems)
-		response := buildCartResponse(cart, cartItems)
+		response, err := buildCartResponse(db, cart, cartItems, currency, address)
+		if err != nil {
+			http.Error(w, "Failed to calculate cart totals", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
@@ -231,6 +307,17 @@ func UpdateCartItem(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		currency, err := requestCurrency(db, r)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
+		}
+		address, err := requestAddress(db, r, userID)
+		if err != nil {
+			writeAddressError(w, err)
+			return
+		}
+
 		// Get cart item ID from URL
 		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 		if err != nil {
@@ -279,7 +366,21 @@ func UpdateCartItem(db *gorm.DB) http.HandlerFunc {
 				return
 			}
 
-			if req.Quantity > variant.StockQty {
+			// Other lines may hold the same frame with different lenses
+			var otherLines int64
+			if err := db.Model(&models.CartItem{}).
+				Where("cart_id = ? AND product_variant_id = ? AND id <> ?", cartItem.CartID, cartItem.ProductVariantID, cartItem.ID).
+				Select("COALESCE(SUM(qty), 0)").Scan(&otherLines).Error; err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+
+			available, err := availableStock(db, variant, cartItem.CartID)
+			if err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if req.Quantity+int(otherLines) > available {
 				http.Error(w, "Insufficient stock available", http.StatusBadRequest)
 				return
 			}
@@ -308,7 +409,11 @@ func UpdateCartItem(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		response := buildCartResponse(cart, cartItems)
+		response, err := buildCartResponse(db, cart, cartItems, currency, address)
+		if err != nil {
+			http.Error(w, "Failed to calculate cart totals", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
@@ -399,11 +504,13 @@ func ClearCart(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-// Helper function to build cart response
-func buildCartResponse(cart models.Cart, cartItems []models.CartItem) CartResponse {
+// Helper function to build cart response, with promotions applied, priced in the
+// given currency and taxed for the shipping address
+func buildCartResponse(db *gorm.DB, cart models.Cart, cartItems []models.CartItem, currency displayCurrency, address *models.Address) (CartResponse, error) {
 	var items []CartItemResp
 	var totalItems int
-	var totalPrice float64
+	var totalPrice models.Money
+	var lines []pricingLine
 
 	for _, item := range cartItems {
 		itemResp := CartItemResp{
@@ -411,36 +518,70 @@ func buildCartResponse(cart models.Cart, cartItems []models.CartItem) CartRespon
 			CartID:           item.CartID,
 			ProductVariantID: item.ProductVariantID,
 			Qty:              item.Qty,
-			UnitPrice:        item.UnitPrice,
+			UnitPrice:        currency.convert(item.UnitPrice),
+			LensOptions:      item.LensOptions,
+			LensPrice:        currency.convert(item.LensPrice),
 			Variant: CartVariant{
 				ID:         item.Variant.ID,
 				SKU:        item.Variant.SKU,
 				Color:      item.Variant.Color,
 				Size:       item.Variant.Size,
 				StockQty:   item.Variant.StockQty,
-				ExtraPrice: item.Variant.ExtraPrice,
+				ExtraPrice: currency.convert(item.Variant.ExtraPrice),
 				Product: CartProduct{
-					ID:        item.Variant.Product.ID,
-					Name:      item.Variant.Product.Name,
-					Image:     item.Variant.Product.Image,
-					BasePrice: item.Variant.Product.BasePrice,
+					ID:            item.Variant.Product.ID,
+					Name:          item.Variant.Product.Name,
+					Image:         item.Variant.Product.Image,
+					BasePrice:     currency.convert(item.Variant.Product.BasePrice),
+					AcceptsLenses: item.Variant.Product.AcceptsLenses,
 				},
 			},
 		}
 
 		items = append(items, itemResp)
 		totalItems += item.Qty
-		totalPrice += item.UnitPrice * float64(item.Qty)
+		// The total is the sum of the lines as displayed
+		totalPrice += itemResp.UnitPrice.Mul(item.Qty)
+		lines = append(lines, pricingLine{
+			unitPrice:  item.UnitPrice,
+			lensPrice:  item.LensPrice,
+			qty:        item.Qty,
+			categoryID: item.Variant.Product.CategoryID,
+		})
 	}
 
-	return CartResponse{
-		ID:         cart.ID,
-		UserID:     cart.UserID,
-		Status:     cart.Status,
-		Items:      items,
-		TotalItems: totalItems,
-		TotalPrice: totalPrice,
-		CreatedAt:  cart.CreatedAt,
-		UpdatedAt:  cart.UpdatedAt,
+	// Discounts and taxes are calculated in the base currency and converted like the lines
+	totals, err := priceCart(db, cart.UserID, cart.CouponCode, lines, address, time.Now())
+	if err != nil {
+		return CartResponse{}, err
+	}
+	totals = currency.convertTotals(totals)
+	taxTotal, _ := taxTotals(totals.taxes)
+
+	response := CartResponse{
+		ID:            cart.ID,
+		UserID:        cart.UserID,
+		Status:        cart.Status,
+		Items:         items,
+		TotalItems:    totalItems,
+		TotalPrice:    totalPrice,
+		Subtotal:      totalPrice,
+		Discounts:     totals.discounts,
+		DiscountTotal: totals.discountTotal(),
+		Shipping:      totals.shipping,
+		CouponCode:    cart.CouponCode,
+		Taxes:         totals.taxes,
+		TaxTotal:      taxTotal,
+		GrandTotal:    totals.grandTotal(totalPrice),
+		Currency:      currency.Code,
+		CreatedAt:     cart.CreatedAt,
+		UpdatedAt:     cart.UpdatedAt,
+	}
+	if address != nil {
+		response.AddressID = &address.ID
+	}
+	if totals.couponError != nil {
+		response.CouponError = couponMessage(totals.couponError)
 	}
+	return response, nil
 }
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"backend-optical-store/search"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CategoryNode is a category with its nested subcategories
+type CategoryNode struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	ParentID    *int64          `json:"parent_id,omitempty"`
+	Children    []*CategoryNode `json:"children"`
+}
+
+type categoryRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentID    *int64 `json:"parent_id,omitempty"`
+}
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errCategoryCycle    = errors.New("category cannot be nested under itself or its descendants")
+)
+
+// GetCategories returns all categories as a flat list
+func GetCategories(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categories := []models.Category{}
+		if err := db.Order("name").Find(&categories).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(categories)
+	}
+}
+
+// GetCategoryTree returns the category hierarchy with root categories at the top level
+func GetCategoryTree(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var categories []models.Category
+		if err := db.Order("name").Find(&categories).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		nodes := make(map[int64]*CategoryNode, len(categories))
+		for _, c := range categories {
+			nodes[c.ID] = &CategoryNode{
+				ID:          c.ID,
+				Name:        c.Name,
+				Description: c.Description,
+				ParentID:    c.ParentID,
+				Children:    []*CategoryNode{},
+			}
+		}
+
+		// Attach children in name order; orphans are promoted to roots
+		roots := []*CategoryNode{}
+		for _, c := range categories {
+			node := nodes[c.ID]
+			if c.ParentID != nil {
+				if parent, ok := nodes[*c.ParentID]; ok {
+					parent.Children = append(parent.Children, node)
+					continue
+				}
+			}
+			roots = append(roots, node)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roots)
+	}
+}
+
+// CreateCategory creates a category, optionally nested under a parent
+func CreateCategory(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req categoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.ParentID != nil {
+			if err := categoryExists(db, *req.ParentID); err != nil {
+				writeCategoryError(w, err)
+				return
+			}
+		}
+
+		category := models.Category{
+			Name:        req.Name,
+			Description: req.Description,
+			ParentID:    req.ParentID,
+		}
+		if err := db.Create(&category).Error; err != nil {
+			http.Error(w, "Failed to create category", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(category)
+	}
+}
+
+// UpdateCategory renames or re-parents a category
+func UpdateCategory(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categoryID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+
+		var req categoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+
+		// The cycle check and the save share a transaction holding the rows they read,
+		// so two concurrent moves cannot each pass the check and together form a loop
+		var category models.Category
+		var renamed bool
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var parents map[int64]*int64
+			if req.ParentID != nil {
+				var err error
+				if parents, err = lockCategoryTree(tx); err != nil {
+					return err
+				}
+			}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, categoryID).Error; err != nil {
+				return err
+			}
+			if req.ParentID != nil {
+				if err := checkCategoryParent(parents, categoryID, *req.ParentID); err != nil {
+					return err
+				}
+			}
+
+			renamed = category.Name != req.Name
+			category.Name = req.Name
+			category.Description = req.Description
+			category.ParentID = req.ParentID
+			return tx.Save(&category).Error
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				http.Error(w, "Category not found", http.StatusNotFound)
+			case errors.Is(err, errCategoryNotFound), errors.Is(err, errCategoryCycle):
+				writeCategoryError(w, err)
+			default:
+				http.Error(w, "Failed to update category", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Category names are part of the products' search documents
+		if renamed {
+			if err := search.RefreshCategory(db, category.ID); err != nil {
+				log.Printf("Error updating search index for category %d: %v", category.ID, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(category)
+	}
+}
+
+// DeleteCategory removes a category that no subcategory, product, tax rule or
+// promotion refers to
+func DeleteCategory(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categoryID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+
+		var category models.Category
+		if err := db.First(&category, categoryID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Category not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var children, products, taxRules, promotions int64
+		if err := db.Model(&models.Category{}).Where("parent_id = ?", categoryID).Count(&children).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := db.Model(&models.Product{}).Where("category_id = ?", categoryID).Count(&products).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := db.Model(&models.TaxRule{}).Where("category_id = ?", categoryID).Count(&taxRules).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := db.Model(&models.Promotion{}).Where("category_id = ?", categoryID).Count(&promotions).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if children > 0 || products > 0 || taxRules > 0 || promotions > 0 {
+			http.Error(w, "Category still has subcategories, products, tax rules or promotions", http.StatusConflict)
+			return
+		}
+
+		if err := db.Delete(&category).Error; err != nil {
+			http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// categoryExists returns errCategoryNotFound when no category has the given ID
+func categoryExists(db *gorm.DB, categoryID int64) error {
+	var count int64
+	if err := db.Model(&models.Category{}).Where("id = ?", categoryID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errCategoryNotFound
+	}
+	return nil
+}
+
+// lockCategoryTree locks every category row and returns each category's parent ID.
+// Any row can end up in a cycle, and one statement takes the locks in a fixed order.
+func lockCategoryTree(tx *gorm.DB) (map[int64]*int64, error) {
+	var categories []models.Category
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "parent_id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	parents := make(map[int64]*int64, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c.ParentID
+	}
+	return parents, nil
+}
+
+// checkCategoryParent makes sure parentID exists and is not categoryID or one of its
+// descendants, by walking up from parentID
+func checkCategoryParent(parents map[int64]*int64, categoryID, parentID int64) error {
+	if _, ok := parents[parentID]; !ok {
+		return errCategoryNotFound
+	}
+	// The seen set guards against cycles left by manual edits
+	seen := map[int64]bool{}
+	for id := &parentID; id != nil && !seen[*id]; id = parents[*id] {
+		if *id == categoryID {
+			return errCategoryCycle
+		}
+		seen[*id] = true
+	}
+	return nil
+}
+
+// categoryDescendantIDs returns the category ID followed by the IDs of all its descendants
+func categoryDescendantIDs(db *gorm.DB, categoryID int64) ([]int64, error) {
+	var categories []models.Category
+	if err := db.Select("id", "parent_id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[int64][]int64)
+	for _, c := range categories {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+
+	// Walk breadth-first; the seen set guards against cycles left by manual edits
+	ids := []int64{categoryID}
+	seen := map[int64]bool{categoryID: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func writeCategoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCategoryNotFound):
+		http.Error(w, "Parent category not found", http.StatusBadRequest)
+	case errors.Is(err, errCategoryCycle):
+		http.Error(w, errCategoryCycle.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
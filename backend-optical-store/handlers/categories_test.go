@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckCategoryParent(t *testing.T) {
+	id := func(v int64) *int64 { return &v }
+	// 1 > 2 > 3, 4 on its own, and 5 <-> 6 looping from a manual edit
+	parents := map[int64]*int64{1: nil, 2: id(1), 3: id(2), 4: nil, 5: id(6), 6: id(5)}
+
+	tests := []struct {
+		category, parent int64
+		want             error
+	}{
+		{4, 3, nil},
+		{3, 1, nil},
+		{2, 4, nil},
+		{1, 1, errCategoryCycle},
+		{1, 2, errCategoryCycle},
+		{1, 3, errCategoryCycle},
+		{2, 3, errCategoryCycle},
+		{4, 9, errCategoryNotFound},
+		{4, 5, nil}, // the walk stops at the loop
+		{5, 6, errCategoryCycle},
+	}
+	for _, tt := range tests {
+		if got := checkCategoryParent(parents, tt.category, tt.parent); !errors.Is(got, tt.want) {
+			t.Errorf("checkCategoryParent(%d under %d) = %v, want %v", tt.category, tt.parent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// exchangeRatesTTL bounds how long another instance may keep charging an old rate
+const exchangeRatesTTL = time.Minute
+
+var errUnsupportedCurrency = errors.New("unsupported currency")
+
+var (
+	ratesMu     sync.Mutex
+	rates       map[string]models.Rate
+	ratesLoaded time.Time
+)
+
+// displayCurrency is the currency a response is priced in. Amounts are stored in
+// the base currency and converted one at a time, each rounded to the cent.
+type displayCurrency struct {
+	Code string
+	Rate models.Rate
+}
+
+var baseCurrency = displayCurrency{Code: models.Currency, Rate: models.RateScale}
+
+func (c displayCurrency) convert(m models.Money) models.Money {
+	if c.Rate == models.RateScale {
+		return m
+	}
+	return c.Rate.Convert(m)
+}
+
+// convertBack turns an amount in the currency into the base currency
+func (c displayCurrency) convertBack(m models.Money) models.Money {
+	if c.Rate == models.RateScale {
+		return m
+	}
+	return c.Rate.ConvertBack(m)
+}
+
+// ProductResponse is a product as shown to customers, priced in a display currency.
+// The catalog itself is always stored in the base currency.
+type ProductResponse struct {
+	models.Product
+	Currency string `json:"currency"` // currency of base_price and the variants' extra_price
+}
+
+// convertProduct prices a product and its loaded variants in the currency
+func (c displayCurrency) convertProduct(p models.Product) ProductResponse {
+	p.BasePrice = c.convert(p.BasePrice)
+	for i := range p.Variants {
+		p.Variants[i].ExtraPrice = c.convert(p.Variants[i].ExtraPrice)
+	}
+	return ProductResponse{Product: p, Currency: c.Code}
+}
+
+// convertTaxes prices tax lines in the currency
+func (c displayCurrency) convertTaxes(lines []models.TaxLine) []models.TaxLine {
+	converted := make([]models.TaxLine, len(lines))
+	for i, line := range lines {
+		line.Taxable = c.convert(line.Taxable)
+		line.Amount = c.convert(line.Amount)
+		converted[i] = line
+	}
+	return converted
+}
+
+// convertTotals prices the discounts, shipping and taxes of a cart in the currency
+func (c displayCurrency) convertTotals(t cartTotals) cartTotals {
+	discounts := make([]models.DiscountLine, len(t.discounts))
+	for i, line := range t.discounts {
+		line.Amount = c.convert(line.Amount)
+		discounts[i] = line
+	}
+	t.discounts = discounts
+	t.shipping = c.convert(t.shipping)
+	t.taxes = c.convertTaxes(t.taxes)
+	return t
+}
+
+// requestCurrency is the currency asked for with ?currency= or the X-Currency
+// header, the base currency when neither is set
+func requestCurrency(db *gorm.DB, r *http.Request) (displayCurrency, error) {
+	code := r.URL.Query().Get("currency")
+	if code == "" {
+		code = r.Header.Get("X-Currency")
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" || code == models.Currency {
+		return baseCurrency, nil
+	}
+	if !models.ValidCurrencyCode(code) {
+		return displayCurrency{}, errUnsupportedCurrency
+	}
+
+	rate, err := exchangeRate(db, code)
+	if err != nil {
+		return displayCurrency{}, err
+	}
+	return displayCurrency{Code: code, Rate: rate}, nil
+}
+
+// writeCurrencyError reports a requestCurrency failure
+func writeCurrencyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnsupportedCurrency) {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// exchangeRate returns the cached rate of a currency, reloading the table when stale
+func exchangeRate(db *gorm.DB, code string) (models.Rate, error) {
+	ratesMu.Lock()
+	defer ratesMu.Unlock()
+
+	if rates == nil || time.Since(ratesLoaded) >= exchangeRatesTTL {
+		var stored []models.ExchangeRate
+		if err := db.Find(&stored).Error; err != nil {
+			return 0, err
+		}
+		rates = make(map[string]models.Rate, len(stored))
+		for _, er := range stored {
+			rates[er.Currency] = er.Rate
+		}
+		ratesLoaded = time.Now()
+	}
+
+	rate, ok := rates[code]
+	if !ok {
+		return 0, errUnsupportedCurrency
+	}
+	return rate, nil
+}
+
+// invalidateExchangeRates makes the next lookup reload the rates
+func invalidateExchangeRates() {
+	ratesMu.Lock()
+	rates = nil
+	ratesMu.Unlock()
+}
+
+// ExchangeRatesResponse lists the currencies prices can be shown in
+type ExchangeRatesResponse struct {
+	Base  string                `json:"base"`
+	Rates []models.ExchangeRate `json:"rates"`
+}
+
+type exchangeRateRequest struct {
+	Rate models.Rate `json:"rate"`
+}
+
+// GetExchangeRates lists the supported currencies and their rates from the base currency
+func GetExchangeRates(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := ExchangeRatesResponse{Base: models.Currency, Rates: []models.ExchangeRate{}}
+		if err := db.Order("currency").Find(&response.Rates).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// PutExchangeRate creates or updates the rate of a currency
+func PutExchangeRate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		code := strings.ToUpper(chi.URLParam(r, "currency"))
+		if !models.ValidCurrencyCode(code) || code == models.Currency {
+			http.Error(w, "Currency must be a three-letter ISO 4217 code other than "+models.Currency, http.StatusBadRequest)
+			return
+		}
+
+		var req exchangeRateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Rate <= 0 {
+			http.Error(w, "Rate must be a positive decimal with at most six decimal places", http.StatusBadRequest)
+			return
+		}
+
+		rate := models.ExchangeRate{Currency: code, Rate: req.Rate, UpdatedBy: &adminID}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "currency"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_by", "updated_at"}),
+		}).Create(&rate).Error
+		if err == nil {
+			err = db.Where("currency = ?", code).First(&rate).Error
+		}
+		if err != nil {
+			http.Error(w, "Failed to save exchange rate", http.StatusInternalServerError)
+			return
+		}
+		invalidateExchangeRates()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rate)
+	}
+}
+
+// DeleteExchangeRate stops offering a currency; placed orders keep the rate they were charged at
+func DeleteExchangeRate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := strings.ToUpper(chi.URLParam(r, "currency"))
+		result := db.Where("currency = ?", code).Delete(&models.ExchangeRate{})
+		if result.Error != nil {
+			http.Error(w, "Failed to delete exchange rate", http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected == 0 {
+			http.Error(w, "Exchange rate not found", http.StatusNotFound)
+			return
+		}
+		invalidateExchangeRates()
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
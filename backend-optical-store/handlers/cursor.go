@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errCursorSort    = errors.New("cursor pagination is not available for this sort")
+)
+
+// pageCursor marks the last item of a page in keyset pagination: its sort key
+// value and ID. Clients get it base64 encoded and treat it as opaque.
+type pageCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v,omitempty"`
+	ID    int64       `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor issued for the given sort
+func decodeCursor(s, sort string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort || c.ID <= 0 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+func writeCursorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidCursor):
+		http.Error(w, "Invalid cursor, start again without one", http.StatusBadRequest)
+	case errors.Is(err, errCursorSort):
+		http.Error(w, "Cursor pagination needs a sort other than relevance", http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
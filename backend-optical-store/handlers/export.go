@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is how many products are loaded at a time while streaming an export
+const exportBatchSize = 200
+
+// exportColumns is the CSV header; the first columns match the import format and
+// the rest are exportOnlyColumns, which the import skips
+var exportColumns = []string{
+	"product_name", "description", "base_price", "category", "accepts_lenses",
+	"sku", "color", "size", "extra_price", "stock_qty", "reorder_point",
+	"product_id", "variant_id", "price", "product_image_url", "image_url",
+}
+
+// FeedItem is one variant in the marketplace product feed
+type FeedItem struct {
+	ID           string `json:"id"` // SKU
+	ItemGroupID  string `json:"item_group_id"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	CategoryID   int64  `json:"category_id"`
+	Color        string `json:"color,omitempty"`
+	Size         string `json:"size,omitempty"`
+	Price        string `json:"price"`        // e.g. "299.99 BRL"
+	Availability string `json:"availability"` // "in stock" or "out of stock"
+	Quantity     int    `json:"quantity"`
+	ImageLink    string `json:"image_link,omitempty"`
+}
+
+// ExportProducts streams the catalog as CSV (default) or, with ?format=json, as a
+// marketplace product feed. The GetProducts filters apply.
+func ExportProducts(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "json" {
+			http.Error(w, "Format must be csv or json", http.StatusBadRequest)
+			return
+		}
+
+		query, _, err := productFilters(db, r, baseCurrency)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		baseURL := uploadsBaseURL(r)
+		filename := "catalog-" + time.Now().Format("20060102") + "." + format
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			writer := csv.NewWriter(w)
+			writer.Write(exportColumns)
+
+			err = eachProductBatch(query, func(products []models.Product) error {
+				for _, p := range products {
+					if len(p.Variants) == 0 {
+						// Keep products without variants visible to the accountant
+						writer.Write(exportRow(p, models.Variant{}, baseURL))
+						continue
+					}
+					for _, v := range p.Variants {
+						writer.Write(exportRow(p, v, baseURL))
+					}
+				}
+				writer.Flush()
+				flush(w)
+				return writer.Error()
+			})
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			encoder := json.NewEncoder(w)
+			first := true
+			w.Write([]byte(`{"generated_at":"` + time.Now().UTC().Format(time.RFC3339) + `","items":[`))
+
+			err = eachProductBatch(query, func(products []models.Product) error {
+				for _, p := range products {
+					for _, v := range p.Variants {
+						if !first {
+							w.Write([]byte(","))
+						}
+						first = false
+						if err := encoder.Encode(feedItem(p, v, baseURL)); err != nil {
+							return err
+						}
+					}
+				}
+				flush(w)
+				return nil
+			})
+			w.Write([]byte("]}\n"))
+		}
+
+		// Headers are gone by now, so a failure can only be logged
+		if err != nil {
+			log.Printf("Error exporting catalog: %v", err)
+		}
+	}
+}
+
+// eachProductBatch walks the filtered products in ID order, exportBatchSize at a time,
+// so the export never holds the whole catalog in memory
+func eachProductBatch(query *gorm.DB, fn func([]models.Product) error) error {
+	var lastID int64
+	for {
+		var products []models.Product
+		err := query.Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+			Where("products.id > ?", lastID).
+			Order("products.id").
+			Limit(exportBatchSize).
+			Find(&products).Error
+		if err != nil {
+			return err
+		}
+		if len(products) == 0 {
+			return nil
+		}
+		if err := fn(products); err != nil {
+			return err
+		}
+		lastID = products[len(products)-1].ID
+	}
+}
+
+func exportRow(p models.Product, v models.Variant, baseURL string) []string {
+	row := []string{
+		p.Name,
+		p.Description,
+		p.BasePrice.String(),
+		strconv.FormatInt(p.CategoryID, 10),
+		strconv.FormatBool(p.AcceptsLenses),
+		v.SKU,
+		v.Color,
+		v.Size,
+		"", "", "",
+		strconv.FormatInt(p.ID, 10),
+		"",
+		p.BasePrice.String(),
+		uploadURL(baseURL, p.Image),
+		"",
+	}
+	if v.ID != 0 {
+		row[8] = v.ExtraPrice.String()
+		row[9] = strconv.Itoa(v.StockQty)
+		row[10] = strconv.Itoa(v.ReorderPoint)
+		row[12] = strconv.FormatInt(v.ID, 10)
+		row[13] = (p.BasePrice + v.ExtraPrice).String()
+		row[15] = uploadURL(baseURL, v.ImageURL)
+	}
+	return row
+}
+
+func feedItem(p models.Product, v models.Variant, baseURL string) FeedItem {
+	title := p.Name
+	for _, attr := range []string{v.Color, v.Size} {
+		if attr != "" {
+			title += " - " + attr
+		}
+	}
+
+	availability := "out of stock"
+	if v.StockQty > 0 {
+		availability = "in stock"
+	}
+
+	// Fall back to the product photo when the variant has none
+	image := uploadURL(baseURL, v.ImageURL)
+	if image == "" {
+		image = uploadURL(baseURL, p.Image)
+	}
+
+	return FeedItem{
+		ID:           v.SKU,
+		ItemGroupID:  strconv.FormatInt(p.ID, 10),
+		Title:        title,
+		Description:  p.Description,
+		CategoryID:   p.CategoryID,
+		Color:        v.Color,
+		Size:         v.Size,
+		Price:        (p.BasePrice + v.ExtraPrice).String() + " " + models.Currency,
+		Availability: availability,
+		Quantity:     v.StockQty,
+		ImageLink:    image,
+	}
+}
+
+// uploadsBaseURL is the absolute URL uploaded images are served from.
+// PUBLIC_BASE_URL overrides the host the request came in on.
+func uploadsBaseURL(r *http.Request) string {
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + "/api/uploads/"
+}
+
+func uploadURL(baseURL, filename string) string {
+	if filename == "" {
+		return ""
+	}
+	return baseURL + filename
+}
+
+// flush pushes what has been written so far to the client
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"backend-optical-store/models"
+	"backend-optical-store/search"
+)
+
+// ProductFacets counts the products matching the current filters per filter value.
+// Each facet ignores its own filter, so the sidebar keeps showing the other
+// options of a filter the customer has already narrowed.
+type ProductFacets struct {
+	Colors      []FacetCount    `json:"colors"`
+	Sizes       []FacetCount    `json:"sizes"`
+	Categories  []CategoryFacet `json:"categories"`
+	PriceRanges []PriceFacet    `json:"price_ranges"`
+}
+
+// FacetCount is the number of products having a variant with the given value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// CategoryFacet is the number of matching products in a category
+type CategoryFacet struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// PriceFacet is the number of matching products with a base price in [Min, Max),
+// in the display currency
+type PriceFacet struct {
+	Min   models.Money  `json:"min"`
+	Max   *models.Money `json:"max"` // nil for the open-ended top bucket
+	Count int64         `json:"count"`
+}
+
+// priceBucketBounds are the lower bounds of the price buckets in any display currency
+var priceBucketBounds = []models.Money{0, 100_00, 200_00, 300_00, 500_00, 1000_00}
+
+// productFacets computes the facet counts for the request's filters
+func productFacets(db *gorm.DB, r *http.Request, searchQuery search.Query, currency displayCurrency) (*ProductFacets, error) {
+	facets := &ProductFacets{}
+
+	colors, err := variantFacet(db, r, searchQuery, currency, "color")
+	if err != nil {
+		return nil, err
+	}
+	facets.Colors = colors
+
+	sizes, err := variantFacet(db, r, searchQuery, currency, "size")
+	if err != nil {
+		return nil, err
+	}
+	facets.Sizes = sizes
+
+	matching, err := filterProducts(db, r, searchQuery, currency, "category")
+	if err != nil {
+		return nil, err
+	}
+	facets.Categories = []CategoryFacet{}
+	err = db.Table("products").
+		Select("categories.id, categories.name, COUNT(*) AS count").
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Where("products.id IN (?)", matching.Select("products.id")).
+		Group("categories.id, categories.name").
+		Order("count DESC, categories.name").
+		Scan(&facets.Categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	facets.PriceRanges, err = priceFacet(db, r, searchQuery, currency)
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// variantFacet counts matching products per distinct variant color or size
+func variantFacet(db *gorm.DB, r *http.Request, searchQuery search.Query, currency displayCurrency, column string) ([]FacetCount, error) {
+	matching, err := filterProducts(db, r, searchQuery, currency, column)
+	if err != nil {
+		return nil, err
+	}
+
+	// The other variant filter still applies to the variant being counted
+	query := db.Table("variants AS v").
+		Select("v."+column+" AS value, COUNT(DISTINCT v.product_id) AS count").
+		Where("v.product_id IN (?)", matching.Select("products.id")).
+		Where("v." + column + " <> ''")
+	if otherFilter, args := variantFilterSQL(r, column); otherFilter != "" {
+		query = query.Where(strings.TrimPrefix(otherFilter, " AND "), args...)
+	}
+
+	counts := []FacetCount{}
+	err = query.Group("v." + column).Order("count DESC, value").Scan(&counts).Error
+	return counts, err
+}
+
+// priceFacet counts matching products per price bucket in a single pass
+func priceFacet(db *gorm.DB, r *http.Request, searchQuery search.Query, currency displayCurrency) ([]PriceFacet, error) {
+	matching, err := filterProducts(db, r, searchQuery, currency, "price")
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]PriceFacet, len(priceBucketBounds))
+	columns := make([]string, len(priceBucketBounds))
+	args := []interface{}{}
+	for i, min := range priceBucketBounds {
+		buckets[i].Min = min
+		if i+1 < len(priceBucketBounds) {
+			max := priceBucketBounds[i+1]
+			buckets[i].Max = &max
+			columns[i] = fmt.Sprintf("COALESCE(SUM(price >= ? AND price < ?), 0) AS b%d", i)
+			args = append(args, currency.convertBack(min), currency.convertBack(max))
+		} else {
+			columns[i] = fmt.Sprintf("COALESCE(SUM(price >= ?), 0) AS b%d", i)
+			args = append(args, currency.convertBack(min))
+		}
+	}
+
+	counts := make([]interface{}, len(buckets))
+	for i := range buckets {
+		counts[i] = &buckets[i].Count
+	}
+	// Work out each product's price once, then bucket it
+	prices := db.Table("products").
+		Select(productPriceSQL+" AS price").
+		Where("products.id IN (?)", matching.Select("products.id"))
+	err = db.Table("(?) AS prices", prices).
+		Select(strings.Join(columns, ", "), args...).
+		Row().Scan(counts...)
+	if err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
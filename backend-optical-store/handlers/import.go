@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"backend-optical-store/search"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ImportError points at a problem in one line of an uploaded CSV
+type ImportError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportReport summarizes a catalog import, or what it would do in dry-run mode
+type ImportReport struct {
+	DryRun          bool          `json:"dry_run"`
+	Rows            int           `json:"rows"`
+	ProductsCreated int           `json:"products_created"`
+	ProductsUpdated int           `json:"products_updated"`
+	VariantsCreated int           `json:"variants_created"`
+	VariantsUpdated int           `json:"variants_updated"`
+	Errors          []ImportError `json:"errors"`
+}
+
+// importColumns are the CSV columns we understand, in the order the template lists them
+var importColumns = []struct {
+	name     string
+	required bool
+}{
+	{"product_name", true},
+	{"description", false},
+	{"base_price", true},
+	{"category", true}, // category ID or exact name
+	{"accepts_lenses", false},
+	{"sku", true},
+	{"color", false},
+	{"size", false},
+	{"extra_price", false},
+	{"stock_qty", false}, // empty leaves an existing variant's stock alone
+	{"reorder_point", false},
+	{"product_id", false}, // picks the product to update; otherwise matched by SKU, then by name
+}
+
+// exportOnlyColumns are written by the catalog export and skipped on import, so an
+// export can be edited and uploaded again
+var exportOnlyColumns = map[string]bool{
+	"variant_id":        true,
+	"price":             true,
+	"product_image_url": true,
+	"image_url":         true,
+}
+
+const maxImportSize = 10 << 20 // 10 MB
+
+// importRow is one validated CSV line: a variant together with its product's fields
+type importRow struct {
+	line          int
+	productName   string
+	description   string
+	basePrice     models.Money
+	categoryID    int64
+	acceptsLenses bool
+	sku           string
+	color         string
+	size          string
+	extraPrice    models.Money
+	stockQty      *int
+	reorderPoint  int
+	productID     int64 // from the product_id column, 0 when empty
+	existing      *models.Variant
+}
+
+// importProduct groups the rows that describe one product
+type importProduct struct {
+	rows      []*importRow
+	productID int64 // existing product, 0 when it will be created
+}
+
+// importPlan is the validated content of an upload, ready to be applied
+type importPlan struct {
+	columns  map[string]bool
+	products []*importProduct
+}
+
+// ImportProducts creates or updates products and variants from a CSV upload.
+// Every line is validated before anything is written; with ?dry_run=true the
+// report is returned without touching the catalog. Variants are matched by SKU.
+// Rows belong to the product given by product_id, else the product of their SKU,
+// else the product with their product_name, and must agree on its fields.
+func ImportProducts(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+		// Accept either a multipart "file" field or a raw text/csv body
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		var body io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			if err := r.ParseMultipartForm(maxImportSize); err != nil {
+				http.Error(w, "Unable to parse form", http.StatusBadRequest)
+				return
+			}
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "CSV file is required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			body = file
+		}
+
+		report := ImportReport{DryRun: dryRun, Errors: []ImportError{}}
+		plan, err := parseImport(db, body, &report)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(report.Errors) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(report)
+			return
+		}
+
+		if !dryRun {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return applyImport(tx, plan, userID)
+			})
+			if err != nil {
+				if errors.Is(err, errInsufficientStock) || strings.Contains(err.Error(), "Duplicate entry") {
+					http.Error(w, "Catalog changed during import, please retry", http.StatusConflict)
+					return
+				}
+				http.Error(w, "Failed to import products", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// parseImport reads and validates the whole CSV, recording problems in report.Errors.
+// The returned error is only for database failures.
+func parseImport(db *gorm.DB, body io.Reader, report *ImportReport) (*importPlan, error) {
+	categories, err := importCategoryLookup(db)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, rows := readImportRows(body, categories, report)
+	if len(report.Errors) > 0 {
+		return nil, nil
+	}
+
+	products, err := importProductLookup(db, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group rows by the product they resolve to; product fields must agree across a
+	// group, so two groups never write the same product
+	byProduct := map[int64]*importProduct{}
+	newProducts := map[string]*importProduct{} // by name
+	productOfName := map[string]int64{}
+	for _, row := range rows {
+		productID, msg := products.resolve(row)
+		if msg != "" {
+			report.Errors = append(report.Errors, ImportError{Line: row.line, Message: msg})
+			continue
+		}
+		if id, seen := productOfName[row.productName]; seen && id != productID {
+			report.Errors = append(report.Errors, ImportError{Line: row.line, Column: "product_name",
+				Message: "Rows for " + row.productName + " belong to different products; set product_id"})
+			continue
+		}
+		productOfName[row.productName] = productID
+
+		var group *importProduct
+		var ok bool
+		if productID == 0 {
+			group, ok = newProducts[row.productName]
+		} else {
+			group, ok = byProduct[productID]
+		}
+		if !ok {
+			group = &importProduct{productID: productID}
+			if productID == 0 {
+				newProducts[row.productName] = group
+			} else {
+				byProduct[productID] = group
+			}
+			plan.products = append(plan.products, group)
+		} else if msg := importProductConflict(group.rows[0], row); msg != "" {
+			report.Errors = append(report.Errors, ImportError{Line: row.line, Message: msg})
+			continue
+		}
+		group.rows = append(group.rows, row)
+	}
+	if len(report.Errors) > 0 {
+		return nil, nil
+	}
+
+	for _, group := range plan.products {
+		if group.productID == 0 {
+			report.ProductsCreated++
+		} else {
+			report.ProductsUpdated++
+		}
+		for _, row := range group.rows {
+			if row.existing == nil {
+				report.VariantsCreated++
+			} else {
+				report.VariantsUpdated++
+			}
+		}
+	}
+	return plan, nil
+}
+
+// readImportRows parses the header and every record of the CSV, recording problems
+// in report.Errors
+func readImportRows(body io.Reader, categories map[string][]int64, report *ImportReport) (*importPlan, []*importRow) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		report.Errors = append(report.Errors, ImportError{Line: 1, Message: "Missing or unreadable header row"})
+		return nil, nil
+	}
+
+	// Map column names to positions; spreadsheets like to prepend a BOM
+	known := map[string]bool{}
+	for _, c := range importColumns {
+		known[c.name] = true
+	}
+	index := map[string]int{}
+	exported := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if exportOnlyColumns[name] {
+			exported = true
+			continue
+		}
+		if !known[name] {
+			report.Errors = append(report.Errors, ImportError{Line: 1, Column: name, Message: "Unknown column"})
+			continue
+		}
+		if _, dup := index[name]; dup {
+			report.Errors = append(report.Errors, ImportError{Line: 1, Column: name, Message: "Duplicate column"})
+			continue
+		}
+		index[name] = i
+	}
+	for _, c := range importColumns {
+		if _, ok := index[c.name]; c.required && !ok {
+			report.Errors = append(report.Errors, ImportError{Line: 1, Column: c.name, Message: "Required column is missing"})
+		}
+	}
+	if len(report.Errors) > 0 {
+		return nil, nil
+	}
+
+	plan := &importPlan{columns: map[string]bool{}}
+	for name := range index {
+		plan.columns[name] = true
+	}
+
+	var rows []*importRow
+	seenSKUs := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report.Errors = append(report.Errors, ImportError{Line: parseErr.Line, Message: parseErr.Err.Error()})
+			} else {
+				report.Errors = append(report.Errors, ImportError{Message: "Unable to read CSV"})
+			}
+			return nil, nil
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			report.Rows++
+			report.Errors = append(report.Errors, ImportError{Line: line, Message: fmt.Sprintf("Expected %d fields, got %d", len(header), len(record))})
+			continue
+		}
+		// The export lists products without variants on a row with no SKU; there is
+		// no variant to import from it
+		if exported && strings.TrimSpace(record[index["sku"]]) == "" {
+			continue
+		}
+		report.Rows++
+
+		row, rowErrors := parseImportRow(line, record, index, categories)
+		if len(rowErrors) > 0 {
+			report.Errors = append(report.Errors, rowErrors...)
+			continue
+		}
+		if first, dup := seenSKUs[skuKey(row.sku)]; dup {
+			report.Errors = append(report.Errors, ImportError{Line: line, Column: "sku", Message: fmt.Sprintf("SKU %s already appears on line %d", row.sku, first)})
+			continue
+		}
+		seenSKUs[skuKey(row.sku)] = line
+		rows = append(rows, row)
+	}
+	if report.Rows == 0 {
+		report.Errors = append(report.Errors, ImportError{Line: 2, Message: "No rows to import"})
+	}
+	return plan, rows
+}
+
+// parseImportRow validates one CSV record
+func parseImportRow(line int, record []string, index map[string]int, categories map[string][]int64) (*importRow, []ImportError) {
+	var errs []ImportError
+	field := func(name string) string {
+		if i, ok := index[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	fail := func(column, message string) {
+		errs = append(errs, ImportError{Line: line, Column: column, Message: message})
+	}
+
+	row := &importRow{
+		line:        line,
+		productName: field("product_name"),
+		description: field("description"),
+		sku:         field("sku"),
+		color:       field("color"),
+		size:        field("size"),
+	}
+
+	if s := field("product_id"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v <= 0 {
+			fail("product_id", "Must be a product ID")
+		}
+		row.productID = v
+	}
+
+	if row.productName == "" {
+		fail("product_name", "Name is required")
+	}
+	if row.sku == "" || len(row.sku) > 64 {
+		fail("sku", "SKU is required (max 64 characters)")
+	}
+
+	if v, err := models.ParseMoney(field("base_price")); err != nil || v <= 0 {
+		fail("base_price", "Valid base price is required")
+	} else {
+		row.basePrice = v
+	}
+
+	category := field("category")
+	if ids := categories[strings.ToLower(category)]; len(ids) == 1 {
+		row.categoryID = ids[0]
+	} else if len(ids) > 1 {
+		fail("category", "Category name is ambiguous, use its ID")
+	} else {
+		fail("category", "Category not found")
+	}
+
+	if s := field("accepts_lenses"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			fail("accepts_lenses", "Must be true or false")
+		}
+		row.acceptsLenses = v
+	}
+	if s := field("extra_price"); s != "" {
+		v, err := models.ParseMoney(s)
+		if err != nil || v < 0 {
+			fail("extra_price", "Valid extra price is required")
+		}
+		row.extraPrice = v
+	}
+	if s := field("stock_qty"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			fail("stock_qty", "Valid stock quantity is required")
+		}
+		row.stockQty = &v
+	}
+	if s := field("reorder_point"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			fail("reorder_point", "Valid reorder point is required")
+		}
+		row.reorderPoint = v
+	}
+
+	return row, errs
+}
+
+// importProductConflict describes how row disagrees with the group's first row, if it does
+func importProductConflict(first, row *importRow) string {
+	switch {
+	case row.productName != first.productName:
+		return fmt.Sprintf("product_name differs from line %d for the same product", first.line)
+	case row.description != first.description:
+		return fmt.Sprintf("description differs from line %d for the same product", first.line)
+	case row.basePrice != first.basePrice:
+		return fmt.Sprintf("base_price differs from line %d for the same product", first.line)
+	case row.categoryID != first.categoryID:
+		return fmt.Sprintf("category differs from line %d for the same product", first.line)
+	case row.acceptsLenses != first.acceptsLenses:
+		return fmt.Sprintf("accepts_lenses differs from line %d for the same product", first.line)
+	}
+	return ""
+}
+
+// skuKey is how SKUs compare: the unique index on variants.sku ignores case
+func skuKey(sku string) string {
+	return strings.ToLower(strings.TrimSpace(sku))
+}
+
+// importProducts is what the catalog already has for the rows of an import
+type importProducts struct {
+	ids    map[int64]bool             // products named by product_id that exist
+	bySKU  map[string]*models.Variant // variants with the rows' SKUs, by skuKey
+	byName map[string][]int64         // products by lower-cased name, as names match case-insensitively
+}
+
+// importProductLookup loads the products and variants the rows may refer to
+func importProductLookup(db *gorm.DB, rows []*importRow) (*importProducts, error) {
+	var skus, names []string
+	var ids []int64
+	for _, row := range rows {
+		skus = append(skus, row.sku)
+		names = append(names, row.productName)
+		if row.productID != 0 {
+			ids = append(ids, row.productID)
+		}
+	}
+
+	var variants []models.Variant
+	if err := db.Where("sku IN ?", skus).Find(&variants).Error; err != nil {
+		return nil, err
+	}
+
+	var products []models.Product
+	query := db.Select("id", "name").Where("name IN ?", names)
+	if len(ids) > 0 {
+		query = query.Or("id IN ?", ids)
+	}
+	if err := query.Find(&products).Error; err != nil {
+		return nil, err
+	}
+	return newImportProducts(variants, products), nil
+}
+
+// newImportProducts indexes the variants and products loaded for an import
+func newImportProducts(variants []models.Variant, products []models.Product) *importProducts {
+	lookup := &importProducts{ids: map[int64]bool{}, bySKU: map[string]*models.Variant{}, byName: map[string][]int64{}}
+	for i := range variants {
+		lookup.bySKU[skuKey(variants[i].SKU)] = &variants[i]
+	}
+	for _, p := range products {
+		lookup.ids[p.ID] = true
+		name := strings.ToLower(p.Name)
+		lookup.byName[name] = append(lookup.byName[name], p.ID)
+	}
+	return lookup
+}
+
+// resolve attaches the row to its existing variant and returns the ID of the product it
+// updates, 0 for a new product, or a message explaining why it can't be resolved
+func (l *importProducts) resolve(row *importRow) (int64, string) {
+	row.existing = l.bySKU[skuKey(row.sku)]
+	switch {
+	case row.productID != 0:
+		if !l.ids[row.productID] {
+			return 0, "Product not found"
+		}
+		if row.existing != nil && row.existing.ProductID != row.productID {
+			return 0, fmt.Sprintf("SKU %s belongs to product %d", row.sku, row.existing.ProductID)
+		}
+		return row.productID, ""
+	case row.existing != nil:
+		return row.existing.ProductID, ""
+	}
+	switch ids := l.byName[strings.ToLower(row.productName)]; len(ids) {
+	case 0:
+		return 0, ""
+	case 1:
+		return ids[0], ""
+	default:
+		return 0, "Several products are named " + row.productName + "; set product_id"
+	}
+}
+
+// importCategoryLookup maps category IDs and lower-cased names to category IDs
+func importCategoryLookup(db *gorm.DB) (map[string][]int64, error) {
+	var categories []models.Category
+	if err := db.Select("id", "name").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	lookup := make(map[string][]int64, 2*len(categories))
+	for _, c := range categories {
+		lookup[strconv.FormatInt(c.ID, 10)] = []int64{c.ID}
+		name := strings.ToLower(c.Name)
+		lookup[name] = append(lookup[name], c.ID)
+	}
+	return lookup, nil
+}
+
+// applyImport writes a validated plan. Columns missing from the file leave existing
+// values alone; stock changes are booked in the inventory ledger.
+func applyImport(tx *gorm.DB, plan *importPlan, userID int64) error {
+	has := plan.columns
+	productIDs := make([]int64, 0, len(plan.products))
+	for _, group := range plan.products {
+		first := group.rows[0]
+
+		var product models.Product
+		if group.productID != 0 {
+			if err := tx.First(&product, group.productID).Error; err != nil {
+				return err
+			}
+		}
+		product.Name = first.productName
+		product.BasePrice = first.basePrice
+		product.CategoryID = first.categoryID
+		if has["description"] || group.productID == 0 {
+			product.Description = first.description
+		}
+		if has["accepts_lenses"] || group.productID == 0 {
+			product.AcceptsLenses = first.acceptsLenses
+		}
+		if err := tx.Omit("Variants").Save(&product).Error; err != nil {
+			return err
+		}
+		productIDs = append(productIDs, product.ID)
+
+		for _, row := range group.rows {
+			var variant models.Variant
+			if row.existing != nil {
+				// Lock the variant so the stock delta is computed against its current level
+				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant, row.existing.ID).Error; err != nil {
+					return err
+				}
+			} else {
+				variant.ProductID = product.ID
+			}
+
+			variant.SKU = row.sku
+			if has["color"] || row.existing == nil {
+				variant.Color = row.color
+			}
+			if has["size"] || row.existing == nil {
+				variant.Size = row.size
+			}
+			if has["extra_price"] || row.existing == nil {
+				variant.ExtraPrice = row.extraPrice
+			}
+			if has["reorder_point"] || row.existing == nil {
+				variant.ReorderPoint = row.reorderPoint
+			}
+			// Existing stock only changes through the ledger below
+			save := tx.Omit("Product")
+			if row.existing != nil {
+				save = save.Omit("Product", "StockQty")
+			}
+			if err := save.Save(&variant).Error; err != nil {
+				return err
+			}
+
+			if row.stockQty == nil || *row.stockQty == variant.StockQty {
+				continue
+			}
+			kind := models.MovementAdjustment
+			if row.existing == nil {
+				kind = models.MovementReceipt
+			}
+			if err := recordMovement(tx, &models.InventoryMovement{
+				VariantID: variant.ID,
+				Kind:      kind,
+				QtyDelta:  *row.stockQty - variant.StockQty,
+				UserID:    &userID,
+				Reason:    "CSV import",
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return search.Refresh(tx, productIDs...)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"backend-optical-store/models"
+)
+
+func TestReadImportRowsDuplicateSKU(t *testing.T) {
+	categories := map[string][]int64{"frames": {1}}
+	tests := []struct {
+		name    string
+		skus    []string
+		wantErr bool
+	}{
+		{"distinct", []string{"RB-001", "RB-002"}, false},
+		{"same case", []string{"RB-001", "RB-001"}, true},
+		{"mixed case", []string{"RB-001", "rb-001"}, true},
+		{"padded", []string{"RB-001", " rb-001"}, true},
+	}
+	for _, tt := range tests {
+		csv := "product_name,base_price,category,sku\n"
+		for _, sku := range tt.skus {
+			csv += "Aviator,99.90,frames," + sku + "\n"
+		}
+		report := ImportReport{}
+		_, rows := readImportRows(strings.NewReader(csv), categories, &report)
+		if gotErr := len(report.Errors) > 0; gotErr != tt.wantErr {
+			t.Errorf("%s: errors = %v, want error %v", tt.name, report.Errors, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			if e := report.Errors[0]; e.Line != 3 || e.Column != "sku" {
+				t.Errorf("%s: error = %+v, want sku on line 3", tt.name, e)
+			}
+		} else if len(rows) != len(tt.skus) {
+			t.Errorf("%s: got %d rows, want %d", tt.name, len(rows), len(tt.skus))
+		}
+	}
+}
+
+func TestImportProductsResolveSKU(t *testing.T) {
+	lookup := newImportProducts(
+		[]models.Variant{{ID: 7, ProductID: 3, SKU: "RB-001"}},
+		[]models.Product{{ID: 3, Name: "Aviator"}},
+	)
+	tests := []struct {
+		sku         string
+		wantProduct int64
+		wantVariant bool
+	}{
+		{"RB-001", 3, true},
+		{"rb-001", 3, true},
+		{"Rb-001", 3, true},
+		{"RB-002", 3, false}, // new variant, matched by product name
+	}
+	for _, tt := range tests {
+		row := &importRow{productName: "Aviator", sku: tt.sku}
+		productID, msg := lookup.resolve(row)
+		if msg != "" || productID != tt.wantProduct {
+			t.Errorf("resolve(%s) = %d, %q; want %d", tt.sku, productID, msg, tt.wantProduct)
+		}
+		if (row.existing != nil) != tt.wantVariant {
+			t.Errorf("resolve(%s) existing = %v, want variant %v", tt.sku, row.existing, tt.wantVariant)
+		}
+	}
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	products := []models.Product{
+		{ID: 3, Name: "Aviator", Description: "Metal frame, \"classic\"", BasePrice: 299_90, CategoryID: 4, AcceptsLenses: true, Image: "aviator.jpg",
+			Variants: []models.Variant{
+				{ID: 7, ProductID: 3, SKU: "AV-GLD", Color: "Gold", ExtraPrice: 20_00, StockQty: 5, ReorderPoint: 2, ImageURL: "aviator-gold.jpg"},
+				{ID: 8, ProductID: 3, SKU: "AV-SLV", Color: "Silver", Size: "58", StockQty: 0},
+			}},
+		{ID: 9, Name: "Cleaning kit", BasePrice: 19_90, CategoryID: 4}, // no variants
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(exportColumns)
+	for _, p := range products {
+		if len(p.Variants) == 0 {
+			writer.Write(exportRow(p, models.Variant{}, "https://shop.example/api/uploads/"))
+		}
+		for _, v := range p.Variants {
+			writer.Write(exportRow(p, v, "https://shop.example/api/uploads/"))
+		}
+	}
+	writer.Flush()
+
+	report := ImportReport{}
+	plan, rows := readImportRows(&buf, map[string][]int64{"4": {4}}, &report)
+	if len(report.Errors) > 0 {
+		t.Fatalf("errors = %+v", report.Errors)
+	}
+	if report.Rows != 2 || len(rows) != 2 {
+		t.Fatalf("got %d rows (%d reported), want the 2 variants", len(rows), report.Rows)
+	}
+	for _, column := range []string{"variant_id", "price", "product_image_url", "image_url"} {
+		if plan.columns[column] {
+			t.Errorf("export-only column %s was imported", column)
+		}
+	}
+
+	p := products[0]
+	for i, row := range rows {
+		v := p.Variants[i]
+		if row.productID != p.ID || row.productName != p.Name || row.description != p.Description ||
+			row.basePrice != p.BasePrice || row.categoryID != p.CategoryID || row.acceptsLenses != p.AcceptsLenses {
+			t.Errorf("line %d: product fields = %+v, want those of %+v", row.line, row, p)
+		}
+		if row.sku != v.SKU || row.color != v.Color || row.size != v.Size || row.extraPrice != v.ExtraPrice ||
+			row.stockQty == nil || *row.stockQty != v.StockQty || row.reorderPoint != v.ReorderPoint {
+			t.Errorf("line %d: variant fields = %+v, want those of %+v", row.line, row, v)
+		}
+	}
+}
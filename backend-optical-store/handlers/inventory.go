@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// StockDrift is a variant whose stored stock disagrees with its ledger
+type StockDrift struct {
+	VariantID int64  `json:"variant_id"`
+	SKU       string `json:"sku"`
+	StockQty  int    `json:"stock_qty"`
+	LedgerQty int    `json:"ledger_qty"`
+	Corrected bool   `json:"corrected"`
+}
+
+// LowStockItem is a variant at or below its reorder point
+type LowStockItem struct {
+	VariantID    int64  `json:"variant_id"`
+	ProductID    int64  `json:"product_id"`
+	ProductName  string `json:"product_name"`
+	SKU          string `json:"sku"`
+	Color        string `json:"color"`
+	Size         string `json:"size"`
+	StockQty     int    `json:"stock_qty"`
+	ReorderPoint int    `json:"reorder_point"`
+	Shortfall    int    `json:"shortfall"` // units needed to get back to the reorder point
+}
+
+type movementRequest struct {
+	VariantID int64  `json:"variant_id"`
+	Kind      string `json:"kind"`
+	Qty       int    `json:"qty"` // signed for adjustments, positive for every other kind
+	Reason    string `json:"reason"`
+}
+
+// PostInventoryMovement records a manual stock movement (receipt, return, adjustment or damage)
+func PostInventoryMovement(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		var req movementRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.VariantID <= 0 || req.Reason == "" {
+			http.Error(w, "Variant ID and reason are required", http.StatusBadRequest)
+			return
+		}
+
+		// Sales only come from orders; the sign of every other kind is implied
+		delta := req.Qty
+		switch req.Kind {
+		case models.MovementReceipt, models.MovementReturn:
+			if req.Qty <= 0 {
+				http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+				return
+			}
+		case models.MovementDamage:
+			if req.Qty <= 0 {
+				http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+				return
+			}
+			delta = -req.Qty
+		case models.MovementAdjustment:
+			if req.Qty == 0 {
+				http.Error(w, "Quantity must not be zero", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Kind must be receipt, return, adjustment or damage", http.StatusBadRequest)
+			return
+		}
+
+		movement := models.InventoryMovement{
+			VariantID: req.VariantID,
+			Kind:      req.Kind,
+			QtyDelta:  delta,
+			UserID:    &userID,
+			Reason:    req.Reason,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return recordMovement(tx, &movement)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				http.Error(w, "Variant not found", http.StatusNotFound)
+			case errors.Is(err, errInsufficientStock):
+				http.Error(w, "Movement would take stock below zero", http.StatusConflict)
+			default:
+				http.Error(w, "Failed to record movement", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(movement)
+	}
+}
+
+// GetInventoryMovements lists ledger entries, newest first, optionally for one variant
+func GetInventoryMovements(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := db.Model(&models.InventoryMovement{})
+		if variantStr := r.URL.Query().Get("variant_id"); variantStr != "" {
+			variantID, err := strconv.ParseInt(variantStr, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+				return
+			}
+			query = query.Where("variant_id = ?", variantID)
+		}
+
+		limit := 100
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+
+		movements := []models.InventoryMovement{}
+		if err := query.Order("id DESC").Limit(limit).Find(&movements).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(movements)
+	}
+}
+
+// ReconcileInventory reports variants whose stock differs from their ledger.
+// On POST the stored stock is also reset to the ledger total.
+func ReconcileInventory(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apply := r.Method == http.MethodPost
+
+		drifts := []StockDrift{}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Table("variants").
+				Select("variants.id AS variant_id, variants.sku, variants.stock_qty, COALESCE(SUM(inventory_movements.qty_delta), 0) AS ledger_qty").
+				Joins("LEFT JOIN inventory_movements ON inventory_movements.variant_id = variants.id").
+				Group("variants.id, variants.sku, variants.stock_qty").
+				Having("variants.stock_qty <> COALESCE(SUM(inventory_movements.qty_delta), 0)").
+				Scan(&drifts).Error
+			if err != nil || !apply {
+				return err
+			}
+
+			for i := range drifts {
+				if err := tx.Model(&models.Variant{}).Where("id = ?", drifts[i].VariantID).
+					Update("stock_qty", drifts[i].LedgerQty).Error; err != nil {
+					return err
+				}
+				drifts[i].Corrected = true
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to reconcile inventory", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drifts)
+	}
+}
+
+// GetLowStockReport lists variants at or below their reorder point, largest shortfall first
+func GetLowStockReport(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var variants []models.Variant
+		if err := db.Scopes(models.LowStock).Preload("Product").
+			Order("reorder_point - stock_qty DESC, id").Find(&variants).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		report := make([]LowStockItem, 0, len(variants))
+		for _, v := range variants {
+			report = append(report, LowStockItem{
+				VariantID:    v.ID,
+				ProductID:    v.ProductID,
+				ProductName:  v.Product.Name,
+				SKU:          v.SKU,
+				Color:        v.Color,
+				Size:         v.Size,
+				StockQty:     v.StockQty,
+				ReorderPoint: v.ReorderPoint,
+				Shortfall:    v.ReorderPoint - v.StockQty,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// recordMovement appends a ledger entry and applies it to the variant's stock.
+// Outgoing movements use a conditional decrement and fail with errInsufficientStock
+// instead of taking stock below zero. Call it inside a transaction.
+func recordMovement(tx *gorm.DB, movement *models.InventoryMovement) error {
+	query := tx.Model(&models.Variant{}).Where("id = ?", movement.VariantID)
+	if movement.QtyDelta < 0 {
+		query = query.Where("stock_qty >= ?", -movement.QtyDelta)
+	}
+
+	result := query.Update("stock_qty", gorm.Expr("stock_qty + ?", movement.QtyDelta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Tell a missing variant apart from a shortage
+		var count int64
+		if err := tx.Model(&models.Variant{}).Where("id = ?", movement.VariantID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return errInsufficientStock
+	}
+
+	return tx.Create(movement).Error
+}
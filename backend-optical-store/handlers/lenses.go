@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+var errInvalidLensOptions = errors.New("invalid lens options")
+
+// GetLensOptions lists the active lens types, indexes and coatings with their prices
+func GetLensOptions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options := []models.LensOption{}
+		if err := db.Where("active = ?", true).Order("kind, price_delta, id").Find(&options).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(options)
+	}
+}
+
+// priceLensOptions validates a lens configuration for the given frame and returns its price.
+// It normalizes opts first, so a coating listed twice is charged and stored once.
+// Validation failures wrap errInvalidLensOptions.
+func priceLensOptions(db *gorm.DB, variant models.Variant, opts *models.LensOptions) (models.Money, error) {
+	if opts == nil {
+		return 0, nil
+	}
+	opts.Normalize()
+	if !variant.Product.AcceptsLenses {
+		return 0, fmt.Errorf("%w: %s cannot be fitted with lenses", errInvalidLensOptions, variant.Product.Name)
+	}
+	if opts.Type == "" || opts.Index == "" {
+		return 0, fmt.Errorf("%w: lens type and index are required", errInvalidLensOptions)
+	}
+
+	var options []models.LensOption
+	if err := db.Where("active = ?", true).Find(&options).Error; err != nil {
+		return 0, err
+	}
+	prices := make(map[string]models.Money, len(options))
+	for _, option := range options {
+		prices[option.Kind+":"+option.Code] = option.PriceDelta
+	}
+
+	var total models.Money
+	lookup := func(kind, code string) error {
+		price, ok := prices[kind+":"+code]
+		if !ok {
+			return fmt.Errorf("%w: unknown lens %s %q", errInvalidLensOptions, kind, code)
+		}
+		total += price
+		return nil
+	}
+
+	if err := lookup(models.LensKindType, opts.Type); err != nil {
+		return 0, err
+	}
+	if err := lookup(models.LensKindIndex, opts.Index); err != nil {
+		return 0, err
+	}
+	for _, coating := range opts.Coatings {
+		if err := lookup(models.LensKindCoating, coating); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
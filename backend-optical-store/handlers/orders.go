@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrdersResponse represents the paginated response for a user's orders
+type OrdersResponse struct {
+	Orders     []models.Order `json:"orders"`
+	Total      int64          `json:"total"`
+	Page       int            `json:"page"`
+	Limit      int            `json:"limit"`
+	TotalPages int            `json:"total_pages"`
+	// NextCursor continues a cursor-paginated listing; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type checkoutRequest struct {
+	PrescriptionID *int64 `json:"prescription_id,omitempty"`
+	// AddressID is the shipping address, which decides the taxes; the default address when omitted
+	AddressID *int64 `json:"address_id,omitempty"`
+}
+
+var (
+	errEmptyCart            = errors.New("cart is empty")
+	errInsufficientStock    = errors.New("insufficient stock")
+	errPrescriptionRequired = errors.New("prescription required")
+	errPrescriptionMismatch = errors.New("prescription does not support the chosen lenses")
+)
+
+// Checkout converts the user's active cart into a pending order
+func Checkout(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		// The body is optional; carts without prescription lenses need no prescription
+		var req checkoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// The order is charged in the currency the cart was shown in, at today's rate
+		currency, err := requestCurrency(db, r)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
+		}
+
+		var order models.Order
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Lock the active cart so concurrent checkouts can't convert it twice
+			var cart models.Cart
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("user_id = ? AND status = ?", userID, "active").
+				First(&cart).Error
+			if err != nil {
+				return err
+			}
+
+			// Load the cart lines in a stable order
+			var cartItems []models.CartItem
+			if err := tx.Where("cart_id = ?", cart.ID).Order("product_variant_id").Find(&cartItems).Error; err != nil {
+				return err
+			}
+			if len(cartItems) == 0 {
+				return errEmptyCart
+			}
+
+			prescriptionID, err := orderPrescription(tx, userID, req.PrescriptionID, cartItems)
+			if err != nil {
+				return err
+			}
+
+			// Without any address the order is untaxed, as it was before taxes existed
+			address, err := shippingAddress(tx, userID, req.AddressID)
+			if err != nil {
+				return err
+			}
+
+			order = models.Order{
+				UserID:         userID,
+				PrescriptionID: prescriptionID,
+				Status:         models.OrderPending,
+				Currency:       currency.Code,
+				ExchangeRate:   currency.Rate,
+				PlacedAt:       time.Now(),
+			}
+			if address != nil {
+				order.AddressID = &address.ID
+				order.ShippingAddress = models.NewOrderAddress(*address)
+			}
+
+			// Re-check and hold the stock against other carts' reservations
+			if _, err := reserveCartStock(tx, cart.ID, cartItems, order.PlacedAt); err != nil {
+				return err
+			}
+
+			var lines []pricingLine
+			var chargedSubtotal models.Money
+			for _, item := range cartItems {
+				var variant models.Variant
+				if err := tx.Preload("Product").First(&variant, item.ProductVariantID).Error; err != nil {
+					return err
+				}
+
+				// Snapshot the cart line into the order
+				order.Items = append(order.Items, models.OrderItem{
+					ProductVariantID: item.ProductVariantID,
+					Qty:              item.Qty,
+					UnitPrice:        item.UnitPrice,
+					LensOptions:      item.LensOptions,
+					LensPrice:        item.LensPrice,
+					ProductID:        variant.ProductID,
+					ProductName:      variant.Product.Name,
+					SKU:              variant.SKU,
+					Color:            variant.Color,
+					Size:             variant.Size,
+					Image:            orderItemImage(variant),
+				})
+				chargedSubtotal += currency.convert(item.UnitPrice).Mul(item.Qty)
+				lines = append(lines, pricingLine{
+					unitPrice:  item.UnitPrice,
+					lensPrice:  item.LensPrice,
+					qty:        item.Qty,
+					categoryID: variant.Product.CategoryID,
+				})
+			}
+
+			// Discount and tax the order like the cart showed it. A coupon that stopped
+			// applying fails the checkout rather than silently charging more.
+			totals, err := priceCart(tx, userID, cart.CouponCode, lines, address, order.PlacedAt)
+			if err != nil {
+				return err
+			}
+			if cart.CouponCode != "" && totals.couponError != nil {
+				return totals.couponError
+			}
+			for _, line := range totals.discounts {
+				order.Discounts = append(order.Discounts, models.OrderDiscount{DiscountLine: line})
+			}
+			for _, line := range totals.taxes {
+				order.Taxes = append(order.Taxes, models.OrderTax{TaxLine: line})
+			}
+			order.Subtotal = totals.subtotal
+			order.DiscountTotal = totals.discountTotal()
+			order.Shipping = totals.shipping
+			order.TaxTotal, _ = taxTotals(totals.taxes)
+			order.Total = totals.grandTotal(totals.subtotal)
+			order.ChargedTotal = currency.convertTotals(totals).grandTotal(chargedSubtotal)
+
+			if err := tx.Create(&order).Error; err != nil {
+				return err
+			}
+
+			// Count the promotions against their usage limits
+			if err := redeemPromotions(tx, totals.applied, userID, order.ID); err != nil {
+				return err
+			}
+
+			// Take the stock through the ledger so every frame sold is accounted for
+			for _, item := range order.Items {
+				if err := recordMovement(tx, &models.InventoryMovement{
+					VariantID: item.ProductVariantID,
+					Kind:      models.MovementSale,
+					QtyDelta:  -item.Qty,
+					UserID:    &userID,
+					OrderID:   &order.ID,
+					Reason:    "checkout",
+				}); err != nil {
+					return err
+				}
+			}
+
+			// The order now owns the stock the cart was holding
+			if err := tx.Model(&models.StockReservation{}).
+				Where("cart_id = ? AND status = ?", cart.ID, models.ReservationActive).
+				Update("status", models.ReservationConsumed).Error; err != nil {
+				return err
+			}
+
+			// Mark the cart as converted so a new active cart is created on next use
+			cart.Status = "converted"
+			cart.UpdatedAt = time.Now()
+			return tx.Save(&cart).Error
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				http.Error(w, "Cart not found", http.StatusNotFound)
+			case errors.Is(err, errEmptyCart):
+				http.Error(w, "Cart is empty", http.StatusBadRequest)
+			case errors.Is(err, errAddressNotFound):
+				http.Error(w, "Address not found", http.StatusNotFound)
+			case isCouponError(err):
+				http.Error(w, couponMessage(err)+"; remove it or review the cart", http.StatusConflict)
+			case errors.Is(err, errPromotionUnavailable):
+				http.Error(w, "A promotion in the cart is no longer available; review the cart", http.StatusConflict)
+			case errors.Is(err, errInsufficientStock):
+				http.Error(w, "Insufficient stock available", http.StatusConflict)
+			case errors.Is(err, errPrescriptionNotFound):
+				http.Error(w, "Prescription not found", http.StatusNotFound)
+			case errors.Is(err, errPrescriptionUnusable):
+				http.Error(w, "Prescription must be verified and not expired", http.StatusUnprocessableEntity)
+			case errors.Is(err, errPrescriptionRequired):
+				http.Error(w, "A verified prescription is required for prescription lenses", http.StatusUnprocessableEntity)
+			case errors.Is(err, errPrescriptionMismatch):
+				http.Error(w, "Progressive and bifocal lenses need a prescription with a near addition (ADD)", http.StatusUnprocessableEntity)
+			default:
+				http.Error(w, "Failed to place order", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Return the created order
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(order)
+	}
+}
+
+// GetOrders returns the authenticated user's orders, newest first
+func GetOrders(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		query := db.Model(&models.Order{}).Where("user_id = ?", userID)
+		writeOrdersPage(w, r, query)
+	}
+}
+
+// GetOrder returns a single order owned by the authenticated user
+func GetOrder(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		// Get order ID from URL
+		orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid order ID", http.StatusBadRequest)
+			return
+		}
+
+		// Scope the lookup to the user so other users' orders read as not found
+		var order models.Order
+		err = db.Preload("Items").Preload("Taxes").Preload("Discounts").Preload("Prescription").
+			Where("orders.id = ? AND orders.user_id = ?", orderID, userID).
+			First(&order).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Order not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(order)
+	}
+}
+
+// AdminGetOrders returns all orders, optionally filtered by status
+func AdminGetOrders(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := db.Model(&models.Order{})
+		if status := r.URL.Query().Get("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		writeOrdersPage(w, r, query)
+	}
+}
+
+type transitionOrderRequest struct {
+	Status string `json:"status"`
+}
+
+// TransitionOrder moves an order to a new status following the order lifecycle
+func TransitionOrder(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get order ID from URL
+		orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid order ID", http.StatusBadRequest)
+			return
+		}
+
+		// Parse request body
+		var req transitionOrderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !models.IsOrderStatus(req.Status) {
+			http.Error(w, "Unknown order status", http.StatusBadRequest)
+			return
+		}
+
+		adminID, _ := r.Context().Value(middleware.UserIDKey).(int64)
+
+		var order models.Order
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+				return err
+			}
+
+			if err := order.TransitionTo(req.Status, time.Now()); err != nil {
+				return err
+			}
+
+			// Put the reserved frames back on the shelf when an order is cancelled
+			// and give its promotions back to the usage limits
+			if order.Status == models.OrderCancelled {
+				if err := releasePromotions(tx, order.ID); err != nil {
+					return err
+				}
+				for _, item := range order.Items {
+					if err := recordMovement(tx, &models.InventoryMovement{
+						VariantID: item.ProductVariantID,
+						Kind:      models.MovementReturn,
+						QtyDelta:  item.Qty,
+						UserID:    &adminID,
+						OrderID:   &order.ID,
+						Reason:    "order cancelled",
+					}); err != nil {
+						return err
+					}
+				}
+			}
+
+			return tx.Omit("Items").Save(&order).Error
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				http.Error(w, "Order not found", http.StatusNotFound)
+			case errors.Is(err, models.ErrInvalidTransition):
+				http.Error(w, "Cannot move order from its current status to "+req.Status, http.StatusConflict)
+			default:
+				http.Error(w, "Failed to update order", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(order)
+	}
+}
+
+// writeOrdersPage paginates an orders query and writes it as an OrdersResponse
+func writeOrdersPage(w http.ResponseWriter, r *http.Request, query *gorm.DB) {
+	pageStr := r.URL.Query().Get("page")
+	limitStr := r.URL.Query().Get("limit")
+
+	// Default pagination values
+	page := 1
+	limit := 10
+
+	// Parse and validate pagination parameters
+	if pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	if r.URL.Query().Has("cursor") {
+		writeOrdersCursorPage(w, r.URL.Query().Get("cursor"), query, limit)
+		return
+	}
+
+	// Get total count for pagination
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Calculate pagination
+	offset := (page - 1) * limit
+	totalPages := int((total + int64(limit) - 1) / int64(limit))
+
+	orders := []models.Order{}
+	if err := query.Preload("Items").Preload("Taxes").Preload("Discounts").Order("placed_at DESC, id DESC").
+		Offset(offset).Limit(limit).Find(&orders).Error; err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := OrdersResponse{
+		Orders:     orders,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// orderPrescription applies the prescription rules for an order and returns the prescription to link.
+// Lines with lenses need a verified, unexpired prescription owned by the user; progressive and
+// bifocal lenses additionally need an ADD value. Orders without lenses are never linked.
+func orderPrescription(tx *gorm.DB, userID int64, prescriptionID *int64, items []models.CartItem) (*int64, error) {
+	needsPrescription, needsAddition := false, false
+	for _, item := range items {
+		if item.LensOptions != nil {
+			needsPrescription = true
+			needsAddition = needsAddition || item.LensOptions.NeedsNearAddition()
+		}
+	}
+	if !needsPrescription {
+		return nil, nil
+	}
+	if prescriptionID == nil {
+		return nil, errPrescriptionRequired
+	}
+
+	// Expired, unverified or foreign prescriptions can't back an order
+	prescription, err := usablePrescription(tx, userID, *prescriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if needsAddition && !prescription.Values.HasNearAddition() {
+		return nil, errPrescriptionMismatch
+	}
+	return &prescription.ID, nil
+}
+
+// orderItemImage picks the variant image, falling back to the product image
+func orderItemImage(variant models.Variant) string {
+	if variant.ImageURL != "" {
+		return variant.ImageURL
+	}
+	return variant.Product.Image
+}
+
+// writeOrdersCursorPage writes the page of orders following cursor, newest first,
+// without counting. New orders never shift later pages.
+func writeOrdersCursorPage(w http.ResponseWriter, cursor string, query *gorm.DB, limit int) {
+	if cursor != "" {
+		after, err := decodeCursor(cursor, "placed_at")
+		if err != nil {
+			writeCursorError(w, err)
+			return
+		}
+		value, _ := after.Value.(string)
+		placedAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			writeCursorError(w, errInvalidCursor)
+			return
+		}
+		query = query.Where("(placed_at < ? OR (placed_at = ? AND id < ?))", placedAt, placedAt, after.ID)
+	}
+
+	// One extra row tells whether there is a next page
+	orders := []models.Order{}
+	if err := query.Preload("Items").Preload("Taxes").Preload("Discounts").Order("placed_at DESC, id DESC").
+		Limit(limit + 1).Find(&orders).Error; err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := OrdersResponse{Limit: limit}
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		response.NextCursor = encodeCursor(pageCursor{
+			Sort:  "placed_at",
+			Value: last.PlacedAt.Format(time.RFC3339Nano),
+			ID:    last.ID,
+		})
+	}
+	response.Orders = orders
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// prescriptionsDir is kept outside ./uploads so prescriptions are never served publicly
+const prescriptionsDir = "./prescriptions"
+
+// allowedPrescriptionTypes maps sniffed content types to stored file extensions
+var allowedPrescriptionTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+}
+
+var (
+	errPrescriptionNotFound = errors.New("prescription not found")
+	errPrescriptionUnusable = errors.New("prescription is not verified or has expired")
+)
+
+type rejectPrescriptionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// UploadPrescription stores a prescription file for the authenticated user
+func UploadPrescription(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		// Parse multipart form
+		err := r.ParseMultipartForm(10 << 20) // 10 MB max
+		if err != nil {
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		issuedAt, err := time.Parse("2006-01-02", r.FormValue("issued_at"))
+		if err != nil {
+			http.Error(w, "Valid issued_at date (YYYY-MM-DD) is required", http.StatusBadRequest)
+			return
+		}
+
+		// Prescriptions are valid for a year unless the document says otherwise
+		expiresAt := issuedAt.AddDate(1, 0, 0)
+		if expiresStr := r.FormValue("expires_at"); expiresStr != "" {
+			expiresAt, err = time.Parse("2006-01-02", expiresStr)
+			if err != nil || !expiresAt.After(issuedAt) {
+				http.Error(w, "expires_at must be a date after issued_at", http.StatusBadRequest)
+				return
+			}
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Prescription file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		// Detect the type from the content rather than trusting the filename
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(file, head)
+		ext, ok := allowedPrescriptionTypes[http.DetectContentType(head[:n])]
+		if !ok {
+			http.Error(w, "Prescription must be a PDF, JPEG or PNG file", http.StatusBadRequest)
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+
+		// Create prescriptions directory if it doesn't exist
+		if err := os.MkdirAll(prescriptionsDir, 0700); err != nil {
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+
+		// Generate unique filename
+		filename := fmt.Sprintf("%d_%d%s", userID, time.Now().UnixNano(), ext)
+		path := filepath.Join(prescriptionsDir, filename)
+		dst, err := os.Create(path)
+		if err != nil {
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+
+		// Leave no partial file behind if it can't be written in full
+		_, err = io.Copy(dst, file)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+
+		prescription := models.Prescription{
+			UserID:    userID,
+			FileURL:   filename,
+			IssuedAt:  issuedAt,
+			ExpiresAt: expiresAt,
+			Status:    models.PrescriptionPending,
+		}
+		if err := db.Create(&prescription).Error; err != nil {
+			os.Remove(path)
+			http.Error(w, "Failed to create prescription", http.StatusInternalServerError)
+			return
+		}
+		prescription.Expired = prescription.IsExpired(time.Now())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(prescription)
+	}
+}
+
+// GetPrescriptions lists the authenticated user's prescriptions, newest first
+func GetPrescriptions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		prescriptions := []models.Prescription{}
+		if err := db.Where("user_id = ?", userID).Order("issued_at DESC, id DESC").Find(&prescriptions).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prescriptions)
+	}
+}
+
+// GetPrescriptionFile serves a prescription document to its owner or to staff
+func GetPrescriptionFile(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID and role from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		role, _ := r.Context().Value(middleware.RoleKey).(string)
+
+		prescriptionID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid prescription ID", http.StatusBadRequest)
+			return
+		}
+
+		query := db.Where("id = ?", prescriptionID)
+		if role != models.RoleAdmin && role != models.RoleOptician {
+			query = query.Where("user_id = ?", userID)
+		}
+
+		var prescription models.Prescription
+		if err := query.First(&prescription).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Prescription not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.ServeFile(w, r, filepath.Join(prescriptionsDir, filepath.Base(prescription.FileURL)))
+	}
+}
+
+// SetPrescriptionValues lets the owner fill in structured values while the prescription awaits review
+func SetPrescriptionValues(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		updatePrescriptionValues(db, w, r, &userID)
+	}
+}
+
+// AdminSetPrescriptionValues lets staff transcribe or correct values before verifying
+func AdminSetPrescriptionValues(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updatePrescriptionValues(db, w, r, nil)
+	}
+}
+
+// GetPrescriptionQueue lists prescriptions awaiting review, oldest first
+func GetPrescriptionQueue(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = models.PrescriptionPending
+		}
+
+		prescriptions := []models.Prescription{}
+		if err := db.Where("status = ?", status).Order("created_at ASC, id ASC").Find(&prescriptions).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prescriptions)
+	}
+}
+
+// VerifyPrescription marks a pending prescription as verified
+func VerifyPrescription(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reviewPrescription(db, w, r, models.PrescriptionVerified, "")
+	}
+}
+
+// RejectPrescription marks a pending prescription as rejected with a reason
+func RejectPrescription(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req rejectPrescriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Reason == "" {
+			http.Error(w, "A rejection reason is required", http.StatusBadRequest)
+			return
+		}
+
+		reviewPrescription(db, w, r, models.PrescriptionRejected, req.Reason)
+	}
+}
+
+// reviewPrescription records a reviewer's decision on a pending prescription
+func reviewPrescription(db *gorm.DB, w http.ResponseWriter, r *http.Request, status, reason string) {
+	reviewerID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	prescriptionID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid prescription ID", http.StatusBadRequest)
+		return
+	}
+
+	var prescription models.Prescription
+	if err := db.First(&prescription, prescriptionID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Prescription not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if prescription.Status != models.PrescriptionPending {
+		http.Error(w, "Prescription has already been reviewed", http.StatusConflict)
+		return
+	}
+	if status == models.PrescriptionVerified && prescription.Expired {
+		http.Error(w, "Expired prescriptions cannot be verified", http.StatusConflict)
+		return
+	}
+	if status == models.PrescriptionVerified && !prescription.Values.HasValues() {
+		http.Error(w, "Prescription values must be filled in before verifying", http.StatusUnprocessableEntity)
+		return
+	}
+
+	now := time.Now()
+	prescription.Status = status
+	prescription.Verified = status == models.PrescriptionVerified
+	prescription.RejectionReason = reason
+	prescription.ReviewedBy = &reviewerID
+	prescription.ReviewedAt = &now
+
+	if err := db.Save(&prescription).Error; err != nil {
+		http.Error(w, "Failed to update prescription", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prescription)
+}
+
+// updatePrescriptionValues validates and stores structured values on an unverified prescription.
+// A non-nil ownerID restricts the update to that user's pending prescriptions.
+func updatePrescriptionValues(db *gorm.DB, w http.ResponseWriter, r *http.Request, ownerID *int64) {
+	prescriptionID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid prescription ID", http.StatusBadRequest)
+		return
+	}
+
+	var values models.PrescriptionValues
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := values.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := db.Where("id = ?", prescriptionID)
+	if ownerID != nil {
+		query = query.Where("user_id = ?", *ownerID)
+	}
+
+	var prescription models.Prescription
+	if err := query.First(&prescription).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Prescription not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Verified values are what orders were made against, so they never change
+	if prescription.Verified || (ownerID != nil && prescription.Status != models.PrescriptionPending) {
+		http.Error(w, "Prescription can no longer be edited", http.StatusConflict)
+		return
+	}
+
+	prescription.Values = values
+	if err := db.Save(&prescription).Error; err != nil {
+		http.Error(w, "Failed to update prescription", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prescription)
+}
+
+// usablePrescription loads a prescription that the user owns and that can back an order
+func usablePrescription(tx *gorm.DB, userID, prescriptionID int64) (*models.Prescription, error) {
+	var prescription models.Prescription
+	if err := tx.Where("id = ? AND user_id = ?", prescriptionID, userID).First(&prescription).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errPrescriptionNotFound
+		}
+		return nil, err
+	}
+	if !prescription.Verified || prescription.Expired {
+		return nil, errPrescriptionUnusable
+	}
+	return &prescription, nil
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errCouponNotApplicable = errors.New("coupon does not apply to this cart")
+
+var (
+	shippingFeeOnce   sync.Once
+	shippingFeeAmount models.Money
+)
+
+// shippingFee is the flat shipping fee of a non-empty cart, set with SHIPPING_FEE
+func shippingFee() models.Money {
+	shippingFeeOnce.Do(func() {
+		if s := os.Getenv("SHIPPING_FEE"); s != "" {
+			fee, err := models.ParseMoney(s)
+			if err != nil || fee < 0 {
+				log.Printf("Ignoring invalid SHIPPING_FEE %q", s)
+				return
+			}
+			shippingFeeAmount = fee
+		}
+	})
+	return shippingFeeAmount
+}
+
+// pricingLine is a cart line as pricing sees it, in the base currency
+type pricingLine struct {
+	unitPrice  models.Money // frame plus lenses
+	lensPrice  models.Money
+	qty        int
+	categoryID int64
+}
+
+// cartTotals is the price breakdown of a cart, in the base currency
+type cartTotals struct {
+	subtotal    models.Money
+	discounts   []models.DiscountLine
+	couponError error // why the cart's coupon gives no discount, nil when it does
+	shipping    models.Money
+	taxes       []models.TaxLine
+	applied     []models.Promotion // promotions behind the discounts, to redeem at checkout
+}
+
+func (t cartTotals) discountTotal() models.Money {
+	var total models.Money
+	for _, d := range t.discounts {
+		total += d.Amount
+	}
+	return total
+}
+
+// grandTotal is what the customer pays for lines adding up to subtotal, which
+// must be in the same currency as the totals
+func (t cartTotals) grandTotal(subtotal models.Money) models.Money {
+	_, added := taxTotals(t.taxes)
+	return subtotal - t.discountTotal() + t.shipping + added
+}
+
+// pricedUnit is one unit of a cart line with what is left to pay for it after
+// the promotions applied so far
+type pricedUnit struct {
+	line       int
+	categoryID int64
+	product    models.Money
+	lenses     models.Money
+}
+
+// priceCart applies the automatic promotions and the cart's coupon, then taxes
+// what is left to pay for the goods for the shipping address
+func priceCart(db *gorm.DB, userID int64, couponCode string, lines []pricingLine, address *models.Address, now time.Time) (cartTotals, error) {
+	var totals cartTotals
+	var units []pricedUnit
+	for i, line := range lines {
+		totals.subtotal += line.unitPrice.Mul(line.qty)
+		for n := 0; n < line.qty; n++ {
+			units = append(units, pricedUnit{
+				line:       i,
+				categoryID: line.categoryID,
+				product:    line.unitPrice - line.lensPrice,
+				lenses:     line.lensPrice,
+			})
+		}
+	}
+	if len(lines) > 0 {
+		totals.shipping = shippingFee()
+	}
+
+	usable, err := cartPromotions(db, userID, couponCode, now)
+	if err != nil {
+		return totals, err
+	}
+	totals.couponError = usable.couponError
+
+	if promotions := usable.promotions; len(promotions) > 0 {
+		parents, err := categoryParents(db)
+		if err != nil {
+			return totals, err
+		}
+		shippingLeft := totals.shipping
+		for _, p := range promotions {
+			amount := applyPromotion(&p, units, parents, &shippingLeft, totals.subtotal)
+			if amount == 0 {
+				if p.Code != nil {
+					totals.couponError = errCouponNotApplicable
+				}
+				continue
+			}
+			line := models.DiscountLine{PromotionID: p.ID, Name: p.Name, Kind: p.Kind, Amount: amount}
+			if p.Code != nil {
+				line.Code = *p.Code
+			}
+			totals.discounts = append(totals.discounts, line)
+			totals.applied = append(totals.applied, p)
+		}
+	}
+
+	// Taxes apply to what is paid for the goods after discounts
+	paid := make([]struct{ product, lenses models.Money }, len(lines))
+	for _, u := range units {
+		paid[u.line].product += u.product
+		paid[u.line].lenses += u.lenses
+	}
+	parts := make([]taxablePart, 0, 2*len(lines))
+	for i, line := range lines {
+		parts = append(parts,
+			taxablePart{target: models.TaxTargetProducts, categoryID: line.categoryID, amount: paid[i].product},
+			taxablePart{target: models.TaxTargetLenses, amount: paid[i].lenses})
+	}
+	taxes, err := calculateTaxes(db, address, parts)
+	if err != nil {
+		return totals, err
+	}
+	totals.taxes = taxes
+	return totals, nil
+}
+
+// usablePromotions are the promotions a cart gets, in the order they apply
+type usablePromotions struct {
+	promotions  []models.Promotion // automatic promotions first, then the coupon
+	couponError error              // why the cart's coupon can't be used, nil when it can
+}
+
+// cartPromotions loads the automatic promotions the user can use now and the cart's coupon
+func cartPromotions(db *gorm.DB, userID int64, couponCode string, now time.Time) (usablePromotions, error) {
+	var usable usablePromotions
+	query := db.Where("code IS NULL AND active = ?", true)
+	if couponCode != "" {
+		query = query.Or("code = ?", couponCode)
+	}
+	var candidates []models.Promotion
+	if err := query.Order("code IS NOT NULL, id").Find(&candidates).Error; err != nil {
+		return usable, err
+	}
+
+	ids := make([]int64, len(candidates))
+	for i, p := range candidates {
+		ids[i] = p.ID
+	}
+	used, err := promotionUsesByUser(db, userID, ids)
+	if err != nil {
+		return usable, err
+	}
+
+	if couponCode != "" {
+		usable.couponError = errCouponNotFound
+	}
+	for _, p := range candidates {
+		availability := p.Available(now, used[p.ID])
+		if p.Code != nil {
+			usable.couponError = availability
+		}
+		if availability == nil {
+			usable.promotions = append(usable.promotions, p)
+		}
+	}
+	return usable, nil
+}
+
+// promotionUsesByUser counts the user's orders per promotion
+func promotionUsesByUser(db *gorm.DB, userID int64, promotionIDs []int64) (map[int64]int, error) {
+	used := make(map[int64]int)
+	if len(promotionIDs) == 0 {
+		return used, nil
+	}
+	var rows []struct {
+		PromotionID int64
+		Uses        int
+	}
+	err := db.Model(&models.PromotionRedemption{}).
+		Select("promotion_id, COUNT(*) AS uses").
+		Where("user_id = ? AND promotion_id IN ?", userID, promotionIDs).
+		Group("promotion_id").
+		Scan(&rows).Error
+	for _, row := range rows {
+		used[row.PromotionID] = row.Uses
+	}
+	return used, err
+}
+
+// applyPromotion takes the promotion's discount off the units, or the shipping fee
+// still to pay, and returns the amount discounted
+func applyPromotion(p *models.Promotion, units []pricedUnit, parents map[int64]int64, shippingLeft *models.Money, subtotal models.Money) models.Money {
+	if subtotal < p.MinSubtotal {
+		return 0
+	}
+
+	var total models.Money
+	switch p.Kind {
+	case models.PromotionFreeShipping:
+		total, *shippingLeft = *shippingLeft, 0
+
+	case models.PromotionPercentOff:
+		for i := range units {
+			for _, share := range promotionShares(p, &units[i], parents) {
+				d := p.Percent.Of(*share)
+				*share -= d
+				total += d
+			}
+		}
+
+	case models.PromotionFixedOff:
+		for i := range units {
+			left := p.Amount
+			for _, share := range promotionShares(p, &units[i], parents) {
+				d := min(left, *share)
+				*share -= d
+				left -= d
+				total += d
+			}
+		}
+
+	case models.PromotionBuyOneGetOne:
+		type match struct {
+			shares []*models.Money
+			value  models.Money
+		}
+		var matches []match
+		for i := range units {
+			m := match{shares: promotionShares(p, &units[i], parents)}
+			for _, share := range m.shares {
+				m.value += *share
+			}
+			if m.value > 0 {
+				matches = append(matches, m)
+			}
+		}
+		// Pair the units from the most expensive down; the second of each pair is free
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].value > matches[j].value })
+		for i := 1; i < len(matches); i += 2 {
+			for _, share := range matches[i].shares {
+				total += *share
+				*share = 0
+			}
+		}
+	}
+	return total
+}
+
+// promotionShares returns the prices of the unit the promotion discounts
+func promotionShares(p *models.Promotion, u *pricedUnit, parents map[int64]int64) []*models.Money {
+	switch p.Target {
+	case models.PromotionTargetLenses:
+		return []*models.Money{&u.lenses}
+	case models.PromotionTargetProducts:
+		if p.CategoryID != nil {
+			if _, ok := categoryDistances(parents, u.categoryID)[*p.CategoryID]; !ok {
+				return nil
+			}
+		}
+		return []*models.Money{&u.product}
+	default:
+		return []*models.Money{&u.product, &u.lenses}
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"testing"
+)
+
+// pricingTestUnits is two frames in category 3 with 100.00 of lenses each and one
+// pair of sunglasses in category 5
+func pricingTestUnits() []pricedUnit {
+	return []pricedUnit{
+		{line: 0, categoryID: 3, product: 200_00, lenses: 100_00},
+		{line: 0, categoryID: 3, product: 200_00, lenses: 100_00},
+		{line: 1, categoryID: 5, product: 150_00},
+	}
+}
+
+func TestApplyPromotion(t *testing.T) {
+	parents := map[int64]int64{3: 1, 5: 4} // 1 Frames > 3, 4 Sunglasses > 5
+	frames := int64(1)
+	const subtotal = 750_00
+
+	tests := []struct {
+		name         string
+		promotion    models.Promotion
+		want         models.Money
+		wantShipping models.Money // shipping left to pay, of 20.00
+	}{
+		{"percent off everything", models.Promotion{Kind: models.PromotionPercentOff, Target: models.PromotionTargetAll, Percent: 10_00}, 75_00, 20_00},
+		{"percent off a category", models.Promotion{Kind: models.PromotionPercentOff, Target: models.PromotionTargetProducts, CategoryID: &frames, Percent: 10_00}, 40_00, 20_00},
+		{"fixed off lenses", models.Promotion{Kind: models.PromotionFixedOff, Target: models.PromotionTargetLenses, Amount: 30_00}, 60_00, 20_00},
+		{"fixed off capped at each unit", models.Promotion{Kind: models.PromotionFixedOff, Target: models.PromotionTargetAll, Amount: 250_00}, 650_00, 20_00},
+		{"bogo frames", models.Promotion{Kind: models.PromotionBuyOneGetOne, Target: models.PromotionTargetProducts, CategoryID: &frames}, 200_00, 20_00},
+		{"bogo everything frees the cheaper of each pair", models.Promotion{Kind: models.PromotionBuyOneGetOne, Target: models.PromotionTargetAll}, 300_00, 20_00},
+		{"free shipping", models.Promotion{Kind: models.PromotionFreeShipping, MinSubtotal: 500_00}, 20_00, 0},
+		{"below minimum subtotal", models.Promotion{Kind: models.PromotionFreeShipping, MinSubtotal: 750_01}, 0, 20_00},
+	}
+	for _, tt := range tests {
+		units := pricingTestUnits()
+		shipping := models.Money(20_00)
+		got := applyPromotion(&tt.promotion, units, parents, &shipping, subtotal)
+		if got != tt.want || shipping != tt.wantShipping {
+			t.Errorf("%s: discount %s, shipping left %s; want %s, %s", tt.name, got, shipping, tt.want, tt.wantShipping)
+		}
+
+		// Whatever was discounted came off the units
+		var left models.Money
+		for _, u := range units {
+			left += u.product + u.lenses
+		}
+		if tt.promotion.Kind != models.PromotionFreeShipping && left != subtotal-got {
+			t.Errorf("%s: units left %s, want %s", tt.name, left, subtotal-got)
+		}
+	}
+}
+
+func TestApplyPromotionStacks(t *testing.T) {
+	// Each promotion only discounts what the previous ones left to pay
+	units := pricingTestUnits()
+	var shipping models.Money
+	half := models.Promotion{Kind: models.PromotionPercentOff, Target: models.PromotionTargetAll, Percent: 50_00}
+	fixed := models.Promotion{Kind: models.PromotionFixedOff, Target: models.PromotionTargetAll, Amount: 200_00}
+
+	if got := applyPromotion(&half, units, nil, &shipping, 750_00); got != 375_00 {
+		t.Fatalf("first discount = %s, want 375.00", got)
+	}
+	// 150.00 left on each frame, 75.00 on the sunglasses
+	if got := applyPromotion(&fixed, units, nil, &shipping, 750_00); got != 375_00 {
+		t.Errorf("second discount = %s, want 375.00", got)
+	}
+	for i, u := range units {
+		if u.product != 0 || u.lenses != 0 {
+			t.Errorf("unit %d still costs %s + %s", i, u.product, u.lenses)
+		}
+	}
+}
+
+func TestCartTotalsGrandTotal(t *testing.T) {
+	totals := cartTotals{
+		subtotal:  300_00,
+		discounts: []models.DiscountLine{{Amount: 30_00}, {Amount: 20_00}},
+		shipping:  15_00,
+		taxes: []models.TaxLine{
+			{Mode: models.TaxExclusive, Amount: 25_00},
+			{Mode: models.TaxInclusive, Amount: 40_00},
+		},
+	}
+	if got := totals.discountTotal(); got != 50_00 {
+		t.Errorf("discountTotal = %s, want 50.00", got)
+	}
+	// Inclusive taxes are already in the prices
+	if got := totals.grandTotal(totals.subtotal); got != 290_00 {
+		t.Errorf("grandTotal = %s, want 290.00", got)
+	}
+}
+
+func TestCouponMessage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errCouponNotFound, "Coupon not found"},
+		{models.ErrPromotionInactive, "Coupon not found"},
+		{models.ErrPromotionExpired, "Coupon has expired"},
+		{models.ErrPromotionUserLimit, "Coupon was already used"},
+		{errCouponNotApplicable, "Coupon does not apply to this cart"},
+		{errPromotionUnavailable, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := couponMessage(tt.err); got != tt.want {
+			t.Errorf("couponMessage(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+		if isCouponError(tt.err) != (tt.want != "") {
+			t.Errorf("isCouponError(%v) disagrees with couponMessage", tt.err)
+		}
+	}
+}
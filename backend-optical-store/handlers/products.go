@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,6 +20,7 @@ import (
 	"gorm.io/gorm"
 
 	"backend-optical-store/models"
+	"backend-optical-store/search"
 )
 
 // logProductsQuery logs the search/filter queries for monitoring
@@ -35,6 +40,12 @@ func GetProduct(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		currency, err := requestCurrency(db, r)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
+		}
+
 		// Query the product with its variants
 		var product models.Product
 		if err := db.Preload("Variants").First(&product, productID).Error; err != nil {
@@ -48,27 +59,27 @@ func GetProduct(db *gorm.DB) http.HandlerFunc {
 
 		// Return the product as JSON
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(product)
+		json.NewEncoder(w).Encode(currency.convertProduct(product))
 	}
 }
 
 // ProductsResponse represents the paginated response for products
 type ProductsResponse struct {
-	Products   []models.Product `json:"products"`
-	Total      int64            `json:"total"`
-	Page       int              `json:"page"`
-	Limit      int              `json:"limit"`
-	TotalPages int              `json:"total_pages"`
+	Products   []ProductResponse `json:"products"`
+	Total      int64             `json:"total"`
+	Page       int               `json:"page"`
+	Limit      int               `json:"limit"`
+	TotalPages int               `json:"total_pages"`
+	// NextCursor continues a cursor-paginated listing; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// CorrectedSearch is the search actually run when typos in the search were corrected
+	CorrectedSearch string         `json:"corrected_search,omitempty"`
+	Facets          *ProductFacets `json:"facets"`
 }
 
 func GetProducts(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse query parameters
-		search := r.URL.Query().Get("search")
-		categoryStr := r.URL.Query().Get("category")
-		priceMinStr := r.URL.Query().Get("price_min")
-		priceMaxStr := r.URL.Query().Get("price_max")
-		stockFilter := r.URL.Query().Get("stock")
 		pageStr := r.URL.Query().Get("page")
 		limitStr := r.URL.Query().Get("limit")
 
@@ -88,66 +99,82 @@ func GetProducts(db *gorm.DB) http.HandlerFunc {
 			}
 		}
 
-		// Build query
-		query := db.Model(&models.Product{}).Preload("Variants")
-
-		// Search filter (name or description)
-		if search != "" {
-			searchTerm := "%" + strings.ToLower(search) + "%"
-			query = query.Where("LOWER(name) LIKE ? OR LOWER(description) LIKE ?", searchTerm, searchTerm)
+		currency, err := requestCurrency(db, r)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
 		}
 
-		// Category filter
-		if categoryStr != "" {
-			if categoryID, err := strconv.ParseInt(categoryStr, 10, 64); err == nil {
-				query = query.Where("category_id = ?", categoryID)
-			}
+		// Build query
+		query, searchQuery, err := productFilters(db, r, currency)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// Count and page through independent copies of the filtered query
+		query = query.Preload("Variants").Session(&gorm.Session{})
+
+		cursorMode := r.URL.Query().Has("cursor")
+		sortName := productSortName(r.URL.Query().Get("sort"), searchQuery, cursorMode)
+		sorted, ok := sortProducts(query, sortName, searchQuery)
+		if !ok {
+			http.Error(w, "Invalid sort, use relevance, price_asc, price_desc, newest, name or best_selling", http.StatusBadRequest)
+			return
 		}
 
-		// Price range filters
-		if priceMinStr != "" {
-			if priceMin, err := strconv.ParseFloat(priceMinStr, 64); err == nil && priceMin >= 0 {
-				query = query.Where("base_price >= ?", priceMin)
+		var products []models.Product
+		var total int64
+		var totalPages int
+		var nextCursor string
+		if cursorMode {
+			// Keyset mode skips the count and stays stable while products are added
+			page = 0
+			products, nextCursor, err = productsAfterCursor(db, sorted, sortName, r.URL.Query().Get("cursor"), limit)
+			if err != nil {
+				writeCursorError(w, err)
+				return
 			}
-		}
-		if priceMaxStr != "" {
-			if priceMax, err := strconv.ParseFloat(priceMaxStr, 64); err == nil && priceMax >= 0 {
-				query = query.Where("base_price <= ?", priceMax)
+		} else {
+			// Get total count for pagination
+			if err := query.Count(&total).Error; err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
 			}
-		}
 
-		// Stock filter (products with available stock)
-		if stockFilter == "available" || stockFilter == "true" {
-			query = query.Joins("JOIN variants ON variants.product_id = products.id").
-				Where("variants.stock_qty > 0").
-				Group("products.id")
-		}
+			// Calculate pagination
+			offset := (page - 1) * limit
+			totalPages = int((total + int64(limit) - 1) / int64(limit))
 
-		// Get total count for pagination
-		var total int64
-		if err := query.Count(&total).Error; err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+			// Query products with pagination
+			if err := sorted.Offset(offset).Limit(limit).Find(&products).Error; err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
 		}
 
-		// Calculate pagination
-		offset := (page - 1) * limit
-		totalPages := int((total + int64(limit) - 1) / int64(limit))
+		priced := make([]ProductResponse, len(products))
+		for i, product := range products {
+			priced[i] = currency.convertProduct(product)
+		}
 
-		// Query products with pagination
-		var products []models.Product
-		if err := query.Offset(offset).Limit(limit).Find(&products).Error; err != nil {
+		facets, err := productFacets(db, r, searchQuery, currency)
+		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
 		// Prepare response
 		response := ProductsResponse{
-			Products:   products,
+			Products:   priced,
 			Total:      total,
 			Page:       page,
 			Limit:      limit,
 			TotalPages: totalPages,
+			NextCursor: nextCursor,
+			Facets:     facets,
+		}
+		if searchQuery.Corrected {
+			response.CorrectedSearch = searchQuery.String()
 		}
 
 		// Log the query for monitoring
@@ -161,6 +188,251 @@ func GetProducts(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
+// productPriceSQL is the lowest price a product sells for: its base price plus the
+// cheapest variant's extra price
+const productPriceSQL = "(products.base_price + COALESCE((SELECT MIN(variants.extra_price) FROM variants WHERE variants.product_id = products.id), 0))"
+
+// productSalesSQL is the number of units sold, not counting cancelled or refunded orders
+const productSalesSQL = "(SELECT COALESCE(SUM(order_items.qty), 0) FROM order_items JOIN orders ON orders.id = order_items.order_id" +
+	" WHERE order_items.product_id = products.id AND orders.status NOT IN ('" + models.OrderCancelled + "', '" + models.OrderRefunded + "'))"
+
+// productSort is an ORDER BY on a sort key, with the product ID breaking ties
+type productSort struct {
+	expr   string // sort key; empty to sort by ID alone
+	desc   bool
+	idDesc bool
+}
+
+// productSorts maps the accepted sort values to their ORDER BY. Only these
+// expressions ever reach the SQL, so the sort parameter cannot inject columns.
+var productSorts = map[string]productSort{
+	"price_asc":    {expr: productPriceSQL},
+	"price_desc":   {expr: productPriceSQL, desc: true},
+	"newest":       {idDesc: true}, // IDs are assigned in creation order
+	"name":         {expr: "products.name"},
+	"best_selling": {expr: productSalesSQL, desc: true, idDesc: true},
+}
+
+func (s productSort) order() string {
+	order := "products.id"
+	if s.idDesc {
+		order += " DESC"
+	}
+	if s.expr == "" {
+		return order
+	}
+	if s.desc {
+		return s.expr + " DESC, " + order
+	}
+	return s.expr + " ASC, " + order
+}
+
+// after restricts a sorted query to the products that come after the cursor
+func (s productSort) after(query *gorm.DB, cursor *pageCursor) *gorm.DB {
+	idCmp := ">"
+	if s.idDesc {
+		idCmp = "<"
+	}
+	if s.expr == "" {
+		return query.Where("products.id "+idCmp+" ?", cursor.ID)
+	}
+	cmp := ">"
+	if s.desc {
+		cmp = "<"
+	}
+	return query.Where("("+s.expr+" "+cmp+" ? OR ("+s.expr+" = ? AND products.id "+idCmp+" ?))",
+		cursor.Value, cursor.Value, cursor.ID)
+}
+
+// productSortName resolves the sort parameter: searches default to relevance and
+// everything else to newest first. Cursor pages can't be ranked by relevance, so
+// they default to newest first even when searching.
+func productSortName(sort string, searchQuery search.Query, cursorMode bool) string {
+	switch {
+	case sort == "" && cursorMode:
+		return "newest"
+	case sort == "" || sort == "relevance":
+		if len(searchQuery.Terms) > 0 {
+			return "relevance"
+		}
+		return "newest"
+	}
+	return sort
+}
+
+// sortProducts orders a products query by a resolved sort name; ok is false for unknown sorts
+func sortProducts(query *gorm.DB, sortName string, searchQuery search.Query) (*gorm.DB, bool) {
+	if sortName == "relevance" {
+		return searchQuery.OrderByRelevance(query).Order("products.id DESC"), true
+	}
+
+	sort, ok := productSorts[sortName]
+	if !ok {
+		return nil, false
+	}
+	return query.Order(sort.order()), true
+}
+
+// productsAfterCursor loads the page of a sorted query following cursor (the first
+// page when it is empty) and returns the cursor for the page after it
+func productsAfterCursor(db *gorm.DB, sorted *gorm.DB, sortName, cursor string, limit int) ([]models.Product, string, error) {
+	// Relevance scores shift as the catalog changes, so they make no stable position
+	sort, ok := productSorts[sortName]
+	if !ok {
+		return nil, "", errCursorSort
+	}
+
+	query := sorted
+	if cursor != "" {
+		after, err := decodeCursor(cursor, sortName)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// The sort key must have the type the sort produces
+		switch after.Value.(type) {
+		case nil:
+			ok = sort.expr == ""
+		case string:
+			ok = sort.expr == "products.name"
+		case float64:
+			ok = sort.expr != "" && sort.expr != "products.name"
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, "", errInvalidCursor
+		}
+		query = sort.after(sorted, after)
+	}
+
+	// One extra row tells whether there is a next page
+	var products []models.Product
+	if err := query.Limit(limit + 1).Find(&products).Error; err != nil {
+		return nil, "", err
+	}
+	if len(products) <= limit {
+		return products, "", nil
+	}
+	products = products[:limit]
+
+	last := products[limit-1]
+	next := pageCursor{Sort: sortName, ID: last.ID}
+	switch sort.expr {
+	case "":
+	case "products.name":
+		next.Value = last.Name
+	default:
+		// Prices (in minor units) and sales counts are both integers
+		var value int64
+		if err := db.Table("products").Select(sort.expr).Where("products.id = ?", last.ID).Row().Scan(&value); err != nil {
+			return nil, "", err
+		}
+		next.Value = value
+	}
+	return products, encodeCursor(next), nil
+}
+
+// productFilters builds the products query shared by the catalog listing and exports,
+// applying the search, category, price, color, size and stock filters from the request.
+// Prices in the request are in the given currency. The parsed search is returned
+// so callers can rank by relevance.
+func productFilters(db *gorm.DB, r *http.Request, currency displayCurrency) (*gorm.DB, search.Query, error) {
+	var searchQuery search.Query
+	if searchStr := r.URL.Query().Get("search"); searchStr != "" {
+		searchQuery = search.Parse(db, searchStr)
+	}
+
+	query, err := filterProducts(db, r, searchQuery, currency, "")
+	return query, searchQuery, err
+}
+
+// filterProducts applies the request's filters except the one named by except
+// ("category", "price", "color" or "size"), which facet counts leave out
+func filterProducts(db *gorm.DB, r *http.Request, searchQuery search.Query, currency displayCurrency, except string) (*gorm.DB, error) {
+	categoryStr := r.URL.Query().Get("category")
+	priceMinStr := r.URL.Query().Get("price_min")
+	priceMaxStr := r.URL.Query().Get("price_max")
+	stockFilter := r.URL.Query().Get("stock")
+
+	query := db.Model(&models.Product{})
+
+	// Full-text search over names, descriptions, categories, SKUs and colors
+	query = searchQuery.Filter(query)
+
+	// Category filter, optionally widened to all subcategories
+	if categoryStr != "" && except != "category" {
+		if categoryID, err := strconv.ParseInt(categoryStr, 10, 64); err == nil {
+			if includeDescendants, _ := strconv.ParseBool(r.URL.Query().Get("include_descendants")); includeDescendants {
+				categoryIDs, err := categoryDescendantIDs(db, categoryID)
+				if err != nil {
+					return nil, err
+				}
+				query = query.Where("products.category_id IN ?", categoryIDs)
+			} else {
+				query = query.Where("products.category_id = ?", categoryID)
+			}
+		}
+	}
+
+	// Price range filters, given in the display currency, on the price the sort uses
+	if priceMinStr != "" && except != "price" {
+		if priceMin, err := models.ParseMoney(priceMinStr); err == nil && priceMin >= 0 {
+			query = query.Where(productPriceSQL+" >= ?", currency.convertBack(priceMin))
+		}
+	}
+	if priceMaxStr != "" && except != "price" {
+		if priceMax, err := models.ParseMoney(priceMaxStr); err == nil && priceMax >= 0 {
+			query = query.Where(productPriceSQL+" <= ?", currency.convertBack(priceMax))
+		}
+	}
+
+	// Color and size filters: some variant must match both
+	if variantFilter, args := variantFilterSQL(r, except); variantFilter != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM variants AS v WHERE v.product_id = products.id"+variantFilter+")", args...)
+	}
+
+	// Stock filter (products with available stock)
+	if stockFilter == "available" || stockFilter == "true" {
+		query = query.Joins("JOIN variants ON variants.product_id = products.id").
+			Where("variants.stock_qty > 0").
+			Group("products.id")
+	}
+
+	// A new session lets callers run several statements on the same filters
+	return query.Session(&gorm.Session{}), nil
+}
+
+// variantFilterSQL returns the color and size conditions on variants aliased v,
+// leaving out the one named by except
+func variantFilterSQL(r *http.Request, except string) (string, []interface{}) {
+	var sql string
+	var args []interface{}
+	if colors := queryValues(r, "color"); len(colors) > 0 && except != "color" {
+		sql += " AND v.color IN ?"
+		args = append(args, colors)
+	}
+	if sizes := queryValues(r, "size"); len(sizes) > 0 && except != "size" {
+		sql += " AND v.size IN ?"
+		args = append(args, sizes)
+	}
+	return sql, args
+}
+
+// queryValues collects a multi-valued parameter given either repeated
+// (color=black&color=red) or comma separated (color=black,red)
+func queryValues(r *http.Request, name string) []string {
+	var values []string
+	for _, param := range r.URL.Query()[name] {
+		for _, v := range strings.Split(param, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
 func CreateProduct(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse multipart form
@@ -175,6 +447,7 @@ func CreateProduct(db *gorm.DB) http.HandlerFunc {
 		description := r.FormValue("description")
 		basePriceStr := r.FormValue("base_price")
 		categoryIDStr := r.FormValue("category_id")
+		acceptsLenses, _ := strconv.ParseBool(r.FormValue("accepts_lenses"))
 
 		// Basic validation
 		if name == "" {
@@ -182,7 +455,7 @@ func CreateProduct(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		basePrice, err := strconv.ParseFloat(basePriceStr, 64)
+		basePrice, err := models.ParseMoney(basePriceStr)
 		if err != nil || basePrice <= 0 {
 			http.Error(w, "Valid base price is required", http.StatusBadRequest)
 			return
@@ -193,52 +466,44 @@ func CreateProduct(db *gorm.DB) http.HandlerFunc {
 			http.Error(w, "Valid category ID is required", http.StatusBadRequest)
 			return
 		}
+		if err := categoryExists(db, categoryID); err != nil {
+			if err == errCategoryNotFound {
+				http.Error(w, "Category not found", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
 		// Handle file upload
 		var imagePath string
-		file, header, err := r.FormFile("image")
+		file, _, err := r.FormFile("image")
 		if err == nil {
 			defer file.Close()
 
-			// Create uploads directory if it doesn't exist
-			uploadsDir := "./uploads"
-			if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-				os.MkdirAll(uploadsDir, 0755)
-			}
-
-			// Generate unique filename
-			ext := filepath.Ext(header.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(),
-				strings.ReplaceAll(name, " ", "_"), ext)
-			imagePath = filename
-
-			// Save file
-			dst, err := os.Create(filepath.Join(uploadsDir, filename))
+			imagePath, err = saveUpload(file, name)
 			if err != nil {
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
-				return
-			}
-			defer dst.Close()
-
-			if _, err := io.Copy(dst, file); err != nil {
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
+				writeUploadError(w, err)
 				return
 			}
 		}
 
 		// Create product
 		product := models.Product{
-			Name:        name,
-			Description: description,
-			BasePrice:   basePrice,
-			CategoryID:  categoryID,
-			Image:       imagePath,
+			Name:          name,
+			Description:   description,
+			BasePrice:     basePrice,
+			CategoryID:    categoryID,
+			Image:         imagePath,
+			AcceptsLenses: acceptsLenses,
 		}
 
 		if err := db.Create(&product).Error; err != nil {
+			removeUpload(imagePath)
 			http.Error(w, "Failed to create product", http.StatusInternalServerError)
 			return
 		}
+		reindexProducts(db, product.ID)
 
 		// Return the created product
 		w.Header().Set("Content-Type", "application/json")
@@ -279,6 +544,7 @@ func UpdateProduct(db *gorm.DB) http.HandlerFunc {
 		description := r.FormValue("description")
 		basePriceStr := r.FormValue("base_price")
 		categoryIDStr := r.FormValue("category_id")
+		acceptsLenses, _ := strconv.ParseBool(r.FormValue("accepts_lenses"))
 
 		// Basic validation
 		if name == "" {
@@ -286,7 +552,7 @@ func UpdateProduct(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		basePrice, err := strconv.ParseFloat(basePriceStr, 64)
+		basePrice, err := models.ParseMoney(basePriceStr)
 		if err != nil || basePrice <= 0 {
 			http.Error(w, "Valid base price is required", http.StatusBadRequest)
 			return
@@ -297,56 +563,47 @@ func UpdateProduct(db *gorm.DB) http.HandlerFunc {
 			http.Error(w, "Valid category ID is required", http.StatusBadRequest)
 			return
 		}
+		if err := categoryExists(db, categoryID); err != nil {
+			if err == errCategoryNotFound {
+				http.Error(w, "Category not found", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
 		// Update basic fields
 		existingProduct.Name = name
 		existingProduct.Description = description
 		existingProduct.BasePrice = basePrice
 		existingProduct.CategoryID = categoryID
+		existingProduct.AcceptsLenses = acceptsLenses
 
-		// Handle file upload if provided
-		file, header, err := r.FormFile("image")
+		// Handle file upload if provided; the old image is only deleted once the
+		// product points at the new one
+		oldImage := existingProduct.Image
+		file, _, err := r.FormFile("image")
 		if err == nil {
 			defer file.Close()
 
-			// Create uploads directory if it doesn't exist
-			uploadsDir := "./uploads"
-			if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-				os.MkdirAll(uploadsDir, 0755)
-			}
-
-			// Delete old image if it exists
-			if existingProduct.Image != "" {
-				oldImagePath := filepath.Join(uploadsDir, existingProduct.Image)
-				if _, err := os.Stat(oldImagePath); err == nil {
-					os.Remove(oldImagePath)
-				}
-			}
-
-			// Generate unique filename
-			ext := filepath.Ext(header.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(),
-				strings.ReplaceAll(name, " ", "_"), ext)
-			existingProduct.Image = filename
-
-			// Save file
-			dst, err := os.Create(filepath.Join(uploadsDir, filename))
+			existingProduct.Image, err = saveUpload(file, name)
 			if err != nil {
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
-				return
-			}
-			defer dst.Close()
-
-			if _, err := io.Copy(dst, file); err != nil {
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
+				writeUploadError(w, err)
 				return
 			}
 		}
 
 		if err := db.Save(&existingProduct).Error; err != nil {
+			if existingProduct.Image != oldImage {
+				removeUpload(existingProduct.Image)
+			}
 			http.Error(w, "Failed to update product", http.StatusInternalServerError)
 			return
 		}
+		if existingProduct.Image != oldImage {
+			removeUpload(oldImage)
+		}
+		reindexProducts(db, existingProduct.ID)
 
 		// Return the updated product
 		w.Header().Set("Content-Type", "application/json")
@@ -375,13 +632,7 @@ func DeleteProduct(db *gorm.DB) http.HandlerFunc {
 		}
 
 		// Delete associated image file if it exists
-		if product.Image != "" {
-			uploadsDir := "./uploads"
-			imagePath := filepath.Join(uploadsDir, product.Image)
-			if _, err := os.Stat(imagePath); err == nil {
-				os.Remove(imagePath)
-			}
-		}
+		removeUpload(product.Image)
 
 		// Delete the product
 		if err := db.Delete(&product, productID).Error; err != nil {
@@ -392,3 +643,107 @@ func DeleteProduct(db *gorm.DB) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// uploadsDir holds public images served under /api/uploads/
+const uploadsDir = "./uploads"
+
+// reindexProducts refreshes the search documents of products after a catalog change.
+// The change itself has already been saved, so failures are only logged.
+func reindexProducts(db *gorm.DB, productIDs ...int64) {
+	if err := search.Refresh(db, productIDs...); err != nil {
+		log.Printf("Error updating search index for products %v: %v", productIDs, err)
+	}
+}
+
+// allowedImageTypes maps sniffed content types to stored image extensions
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var errUnsupportedImage = errors.New("unsupported image type")
+
+// uploadName turns a product name or SKU into a safe filename stem, keeping only
+// letters, digits, dashes and underscores so it can't leave the uploads directory
+func uploadName(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteByte('_')
+		}
+		if b.Len() >= 64 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "image"
+	}
+	return b.String()
+}
+
+// saveUpload stores an uploaded image in the uploads directory and returns its filename.
+// The name only labels the file; a random suffix keeps it unique.
+func saveUpload(file multipart.File, name string) (string, error) {
+	// Detect the type from the content rather than trusting the filename
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	ext, ok := allowedImageTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", errUnsupportedImage
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	// Create uploads directory if it doesn't exist
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	filename := fmt.Sprintf("%s_%s%s", uploadName(name), hex.EncodeToString(suffix), ext)
+	path := filepath.Join(uploadsDir, filename)
+
+	// Save file, leaving nothing behind if it can't be written in full
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return filename, nil
+}
+
+// writeUploadError reports a saveUpload failure
+func writeUploadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnsupportedImage) {
+		http.Error(w, "Image must be a JPEG, PNG, GIF or WebP file", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to save image", http.StatusInternalServerError)
+}
+
+// removeUpload deletes an image from the uploads directory if it exists
+func removeUpload(filename string) {
+	if filename == "" {
+		return
+	}
+	imagePath := filepath.Join(uploadsDir, filepath.Base(filename))
+	if _, err := os.Stat(imagePath); err == nil {
+		os.Remove(imagePath)
+	}
+}
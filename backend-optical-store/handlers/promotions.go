@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errCouponNotFound       = errors.New("coupon not found")
+	errPromotionUnavailable = errors.New("promotion no longer available")
+)
+
+// isCouponError reports whether err explains why a coupon can't be used
+func isCouponError(err error) bool {
+	return couponMessage(err) != ""
+}
+
+// couponMessage is the customer-facing reason a coupon can't be used
+func couponMessage(err error) string {
+	switch {
+	case errors.Is(err, errCouponNotFound), errors.Is(err, models.ErrPromotionInactive):
+		return "Coupon not found"
+	case errors.Is(err, models.ErrPromotionNotStarted):
+		return "Coupon is not valid yet"
+	case errors.Is(err, models.ErrPromotionExpired):
+		return "Coupon has expired"
+	case errors.Is(err, models.ErrPromotionUsedUp):
+		return "Coupon is no longer available"
+	case errors.Is(err, models.ErrPromotionUserLimit):
+		return "Coupon was already used"
+	case errors.Is(err, errCouponNotApplicable):
+		return "Coupon does not apply to this cart"
+	default:
+		return ""
+	}
+}
+
+// redeemPromotions counts an order against the usage limits of its promotions. The
+// conditional update keeps concurrent checkouts from going over a global limit, and
+// the user's row lock serializes their checkouts while the per-user limit is re-checked.
+func redeemPromotions(tx *gorm.DB, promotions []models.Promotion, userID, orderID int64) error {
+	if len(promotions) == 0 {
+		return nil
+	}
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, userID).Error; err != nil {
+		return err
+	}
+	ids := make([]int64, len(promotions))
+	for i, p := range promotions {
+		ids[i] = p.ID
+	}
+	used, err := promotionUsesByUser(tx, userID, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range promotions {
+		if p.PerUserLimit > 0 && used[p.ID] >= p.PerUserLimit {
+			return errPromotionUnavailable
+		}
+		result := tx.Model(&models.Promotion{}).
+			Where("id = ? AND (usage_limit = 0 OR used_count < usage_limit)", p.ID).
+			Update("used_count", gorm.Expr("used_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errPromotionUnavailable
+		}
+		if err := tx.Create(&models.PromotionRedemption{PromotionID: p.ID, UserID: userID, OrderID: orderID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePromotions gives a cancelled order's promotions back to their usage limits.
+// Refunds keep them: a delivered order did use its promotions, and a cancelled one
+// already released them.
+func releasePromotions(tx *gorm.DB, orderID int64) error {
+	var redemptions []models.PromotionRedemption
+	if err := tx.Where("order_id = ?", orderID).Find(&redemptions).Error; err != nil {
+		return err
+	}
+	for _, redemption := range redemptions {
+		if err := tx.Model(&models.Promotion{}).
+			Where("id = ? AND used_count > 0", redemption.PromotionID).
+			Update("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("order_id = ?", orderID).Delete(&models.PromotionRedemption{}).Error
+}
+
+type couponRequest struct {
+	Code string `json:"code"`
+}
+
+// normalizeCouponCode makes codes case-insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ApplyCoupon puts a coupon code on the user's active cart and returns the repriced cart
+func ApplyCoupon(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		var req couponRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		code := normalizeCouponCode(req.Code)
+		if code == "" {
+			http.Error(w, "Coupon code is required", http.StatusBadRequest)
+			return
+		}
+
+		var promotion models.Promotion
+		if err := db.Where("code = ?", code).First(&promotion).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, couponMessage(errCouponNotFound), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		used, err := promotionUsesByUser(db, userID, []int64{promotion.ID})
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := promotion.Available(time.Now(), used[promotion.ID]); err != nil {
+			status := http.StatusUnprocessableEntity
+			if errors.Is(err, models.ErrPromotionInactive) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, couponMessage(err), status)
+			return
+		}
+
+		// Find or create active cart for user
+		var cart models.Cart
+		err = db.Where("user_id = ? AND status = ?", userID, "active").First(&cart).Error
+		if err == gorm.ErrRecordNotFound {
+			cart = models.Cart{
+				UserID:    userID,
+				Status:    "active",
+				CreatedAt: time.Now(),
+			}
+			err = nil
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// Whether the coupon applies to the cart's contents is checked on every read,
+		// as the cart keeps changing
+		cart.CouponCode = code
+		cart.UpdatedAt = time.Now()
+		if err := db.Save(&cart).Error; err != nil {
+			http.Error(w, "Failed to apply coupon", http.StatusInternalServerError)
+			return
+		}
+
+		writeCartResponse(db, w, r, cart)
+	}
+}
+
+// RemoveCoupon takes the coupon off the user's active cart and returns the repriced cart
+func RemoveCoupon(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		var cart models.Cart
+		if err := db.Where("user_id = ? AND status = ?", userID, "active").First(&cart).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Cart not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		cart.CouponCode = ""
+		cart.UpdatedAt = time.Now()
+		if err := db.Save(&cart).Error; err != nil {
+			http.Error(w, "Failed to remove coupon", http.StatusInternalServerError)
+			return
+		}
+
+		writeCartResponse(db, w, r, cart)
+	}
+}
+
+// writeCartResponse loads the cart's items and writes it priced for the request
+func writeCartResponse(db *gorm.DB, w http.ResponseWriter, r *http.Request, cart models.Cart) {
+	currency, err := requestCurrency(db, r)
+	if err != nil {
+		writeCurrencyError(w, err)
+		return
+	}
+	address, err := requestAddress(db, r, cart.UserID)
+	if err != nil {
+		writeAddressError(w, err)
+		return
+	}
+
+	var cartItems []models.CartItem
+	if err := db.Preload("Variant.Product").Where("cart_id = ?", cart.ID).Find(&cartItems).Error; err != nil {
+		http.Error(w, "Failed to load cart items", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := buildCartResponse(db, cart, cartItems, currency, address)
+	if err != nil {
+		http.Error(w, "Failed to calculate cart totals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type promotionRequest struct {
+	Name         string         `json:"name"`
+	Code         string         `json:"code"`
+	Kind         string         `json:"kind"`
+	Target       string         `json:"target"`
+	CategoryID   *int64         `json:"category_id,omitempty"`
+	Percent      models.Percent `json:"percent"`
+	Amount       models.Money   `json:"amount"`
+	MinSubtotal  models.Money   `json:"min_subtotal"`
+	StartsAt     *time.Time     `json:"starts_at,omitempty"`
+	EndsAt       *time.Time     `json:"ends_at,omitempty"`
+	UsageLimit   int            `json:"usage_limit"`
+	PerUserLimit int            `json:"per_user_limit"`
+	Active       *bool          `json:"active,omitempty"`
+}
+
+// normalizePromotion trims and defaults the request, then checks it
+func normalizePromotion(req *promotionRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Code = normalizeCouponCode(req.Code)
+	if req.Target == "" {
+		req.Target = models.PromotionTargetAll
+	}
+
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(req.Code) > 64 {
+		return fmt.Errorf("code must be at most 64 characters")
+	}
+	switch req.Kind {
+	case models.PromotionPercentOff:
+		if req.Percent <= 0 {
+			return fmt.Errorf("percent must be greater than 0")
+		}
+	case models.PromotionFixedOff:
+		if req.Amount <= 0 {
+			return fmt.Errorf("amount must be greater than 0")
+		}
+	case models.PromotionBuyOneGetOne, models.PromotionFreeShipping:
+	default:
+		return fmt.Errorf("kind must be percent_off, fixed_off, bogo or free_shipping")
+	}
+	if req.Target != models.PromotionTargetAll && req.Target != models.PromotionTargetProducts && req.Target != models.PromotionTargetLenses {
+		return fmt.Errorf("target must be all, products or lenses")
+	}
+	if req.CategoryID != nil && req.Target != models.PromotionTargetProducts {
+		return fmt.Errorf("only products promotions can have a category")
+	}
+	if req.MinSubtotal < 0 || req.UsageLimit < 0 || req.PerUserLimit < 0 {
+		return fmt.Errorf("min_subtotal and limits cannot be negative")
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+func applyPromotionRequest(p *models.Promotion, req promotionRequest) {
+	p.Name = req.Name
+	p.Code = nil
+	if req.Code != "" {
+		code := req.Code
+		p.Code = &code
+	}
+	p.Kind = req.Kind
+	p.Target = req.Target
+	p.CategoryID = req.CategoryID
+	p.Percent = req.Percent
+	p.Amount = req.Amount
+	p.MinSubtotal = req.MinSubtotal
+	p.StartsAt = req.StartsAt
+	p.EndsAt = req.EndsAt
+	p.UsageLimit = req.UsageLimit
+	p.PerUserLimit = req.PerUserLimit
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+}
+
+// decodePromotionRequest reads and validates a promotion, writing the error response on failure
+func decodePromotionRequest(db *gorm.DB, w http.ResponseWriter, r *http.Request) (promotionRequest, bool) {
+	var req promotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if err := normalizePromotion(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	if req.CategoryID != nil {
+		if err := categoryExists(db, *req.CategoryID); err != nil {
+			if errors.Is(err, errCategoryNotFound) {
+				http.Error(w, "Category not found", http.StatusBadRequest)
+			} else {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// couponCodeTaken reports whether another promotion already uses the code
+func couponCodeTaken(db *gorm.DB, code *string, promotionID int64) (bool, error) {
+	if code == nil {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&models.Promotion{}).Where("code = ? AND id <> ?", *code, promotionID).Count(&count).Error
+	return count > 0, err
+}
+
+// GetPromotions lists every promotion, automatic and coupon
+func GetPromotions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		promotions := []models.Promotion{}
+		if err := db.Order("id DESC").Find(&promotions).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(promotions)
+	}
+}
+
+// CreatePromotion adds a promotion; with a code it is a coupon, otherwise it applies automatically
+func CreatePromotion(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodePromotionRequest(db, w, r)
+		if !ok {
+			return
+		}
+
+		promotion := models.Promotion{Active: true}
+		applyPromotionRequest(&promotion, req)
+		taken, err := couponCodeTaken(db, promotion.Code, 0)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			http.Error(w, "Coupon code already in use", http.StatusConflict)
+			return
+		}
+
+		if err := db.Create(&promotion).Error; err != nil {
+			http.Error(w, "Failed to create promotion", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(promotion)
+	}
+}
+
+// UpdatePromotion replaces a promotion's rules; its usage count is kept
+func UpdatePromotion(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		promotionID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid promotion ID", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodePromotionRequest(db, w, r)
+		if !ok {
+			return
+		}
+
+		var promotion models.Promotion
+		if err := db.First(&promotion, promotionID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Promotion not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		applyPromotionRequest(&promotion, req)
+		taken, err := couponCodeTaken(db, promotion.Code, promotion.ID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			http.Error(w, "Coupon code already in use", http.StatusConflict)
+			return
+		}
+
+		if err := db.Save(&promotion).Error; err != nil {
+			http.Error(w, "Failed to update promotion", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(promotion)
+	}
+}
+
+// DeletePromotion removes a promotion that no order has used; used ones can be deactivated
+func DeletePromotion(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		promotionID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid promotion ID", http.StatusBadRequest)
+			return
+		}
+
+		var redemptions int64
+		if err := db.Model(&models.PromotionRedemption{}).Where("promotion_id = ?", promotionID).Count(&redemptions).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if redemptions > 0 {
+			http.Error(w, "Promotion has been used by orders; deactivate it instead", http.StatusConflict)
+			return
+		}
+
+		result := db.Delete(&models.Promotion{}, promotionID)
+		if result.Error != nil {
+			http.Error(w, "Failed to delete promotion", http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected == 0 {
+			http.Error(w, "Promotion not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reservationTTL is how long stock stays held once checkout begins
+const reservationTTL = 15 * time.Minute
+
+// CheckoutReservationResponse describes the stock held for a cart during checkout
+type CheckoutReservationResponse struct {
+	CartID       int64                     `json:"cart_id"`
+	ExpiresAt    time.Time                 `json:"expires_at"`
+	Reservations []models.StockReservation `json:"reservations"`
+}
+
+// BeginCheckout reserves stock for every line of the active cart for reservationTTL
+func BeginCheckout(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID from context
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		var response CheckoutReservationResponse
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var cart models.Cart
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("user_id = ? AND status = ?", userID, "active").
+				First(&cart).Error
+			if err != nil {
+				return err
+			}
+
+			var cartItems []models.CartItem
+			if err := tx.Where("cart_id = ?", cart.ID).Find(&cartItems).Error; err != nil {
+				return err
+			}
+			if len(cartItems) == 0 {
+				return errEmptyCart
+			}
+
+			reservations, err := reserveCartStock(tx, cart.ID, cartItems, time.Now())
+			if err != nil {
+				return err
+			}
+
+			response = CheckoutReservationResponse{
+				CartID:       cart.ID,
+				ExpiresAt:    reservations[0].ExpiresAt,
+				Reservations: reservations,
+			}
+			return nil
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				http.Error(w, "Cart not found", http.StatusNotFound)
+			case errors.Is(err, errEmptyCart):
+				http.Error(w, "Cart is empty", http.StatusBadRequest)
+			case errors.Is(err, errInsufficientStock):
+				http.Error(w, "Insufficient stock available", http.StatusConflict)
+			default:
+				http.Error(w, "Failed to reserve stock", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// reserveCartStock replaces the cart's active reservations with fresh ones covering its items.
+// Variant rows are locked in ID order, and stock held by other carts' live reservations
+// is not available. Returns errInsufficientStock when any variant can't be covered.
+func reserveCartStock(tx *gorm.DB, cartID int64, items []models.CartItem, now time.Time) ([]models.StockReservation, error) {
+	if err := releaseCartReservations(tx, cartID); err != nil {
+		return nil, err
+	}
+
+	// Several lines can hold the same frame with different lenses
+	quantities := make(map[int64]int)
+	var variantIDs []int64
+	for _, item := range items {
+		if _, ok := quantities[item.ProductVariantID]; !ok {
+			variantIDs = append(variantIDs, item.ProductVariantID)
+		}
+		quantities[item.ProductVariantID] += item.Qty
+	}
+	sort.Slice(variantIDs, func(i, j int) bool { return variantIDs[i] < variantIDs[j] })
+
+	reservations := make([]models.StockReservation, 0, len(variantIDs))
+	for _, variantID := range variantIDs {
+		var variant models.Variant
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant, variantID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, errInsufficientStock
+			}
+			return nil, err
+		}
+
+		reserved, err := reservedStock(tx, variantID, cartID, now)
+		if err != nil {
+			return nil, err
+		}
+		if variant.StockQty-reserved < quantities[variantID] {
+			return nil, errInsufficientStock
+		}
+
+		reservations = append(reservations, models.StockReservation{
+			CartID:    cartID,
+			VariantID: variantID,
+			Qty:       quantities[variantID],
+			Status:    models.ReservationActive,
+			ExpiresAt: now.Add(reservationTTL),
+		})
+	}
+
+	if err := tx.Create(&reservations).Error; err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// releaseCartReservations gives back whatever the cart is currently holding
+func releaseCartReservations(tx *gorm.DB, cartID int64) error {
+	return tx.Model(&models.StockReservation{}).
+		Where("cart_id = ? AND status = ?", cartID, models.ReservationActive).
+		Update("status", models.ReservationReleased).Error
+}
+
+// reservedStock sums the live reservations other carts hold on a variant
+func reservedStock(db *gorm.DB, variantID, exceptCartID int64, now time.Time) (int, error) {
+	var reserved int64
+	err := db.Model(&models.StockReservation{}).
+		Where("variant_id = ? AND cart_id <> ? AND status = ? AND expires_at > ?",
+			variantID, exceptCartID, models.ReservationActive, now).
+		Select("COALESCE(SUM(qty), 0)").Scan(&reserved).Error
+	return int(reserved), err
+}
+
+// availableStock is the variant's stock minus what other carts are holding at checkout
+func availableStock(db *gorm.DB, variant models.Variant, cartID int64) (int, error) {
+	reserved, err := reservedStock(db, variant.ID, cartID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return variant.StockQty - reserved, nil
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+var errAddressNotFound = errors.New("address not found")
+
+// anyCategory ranks rules without a category below every category match
+const anyCategory = 1 << 20
+
+// taxablePart is the product or lens share of a cart or order line, qty included
+type taxablePart struct {
+	target     string
+	categoryID int64
+	amount     models.Money
+}
+
+// calculateTaxes applies the active tax rules of the address's country and state to the
+// parts. Each rule's tax is rounded once, on the total it applies to. A nil address
+// has no taxes.
+func calculateTaxes(db *gorm.DB, address *models.Address, parts []taxablePart) ([]models.TaxLine, error) {
+	lines := []models.TaxLine{}
+	if address == nil {
+		return lines, nil
+	}
+
+	var rules []models.TaxRule
+	err := db.Where("active = ? AND country = ? AND (state = '' OR state = ?)", true, address.Country, address.State).
+		Order("id").Find(&rules).Error
+	if err != nil || len(rules) == 0 {
+		return lines, err
+	}
+
+	parents, err := categoryParents(db)
+	if err != nil {
+		return nil, err
+	}
+
+	taxable := make(map[int64]models.Money)
+	for _, part := range parts {
+		if part.amount == 0 {
+			continue
+		}
+
+		// Of the rules sharing a name, the most specific one taxes the part
+		distances := categoryDistances(parents, part.categoryID)
+		best := make(map[string]*models.TaxRule)
+		bestRank := make(map[string]int)
+		for i := range rules {
+			rank, ok := taxRuleRank(&rules[i], part, distances)
+			if !ok {
+				continue
+			}
+			if current, seen := bestRank[rules[i].Name]; !seen || rank < current {
+				best[rules[i].Name] = &rules[i]
+				bestRank[rules[i].Name] = rank
+			}
+		}
+		for _, rule := range best {
+			taxable[rule.ID] += part.amount
+		}
+	}
+
+	for _, rule := range rules {
+		amount, ok := taxable[rule.ID]
+		if !ok {
+			continue
+		}
+		line := models.TaxLine{Name: rule.Name, Rate: rule.Rate, Mode: rule.Mode, Taxable: amount}
+		if rule.Mode == models.TaxInclusive {
+			line.Amount = rule.Rate.ContainedIn(amount)
+		} else {
+			line.Amount = rule.Rate.Of(amount)
+		}
+		lines = append(lines, line)
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Name < lines[j].Name })
+	return lines, nil
+}
+
+// taxRuleRank reports whether the rule applies to the part and how specific it is,
+// lower being more specific: the nearest category first, then a state over the whole country
+func taxRuleRank(rule *models.TaxRule, part taxablePart, distances map[int64]int) (int, bool) {
+	if rule.Target != part.target {
+		return 0, false
+	}
+	distance := anyCategory
+	if rule.CategoryID != nil {
+		d, ok := distances[*rule.CategoryID]
+		if !ok {
+			return 0, false
+		}
+		distance = d
+	}
+	rank := distance * 2
+	if rule.State == "" {
+		rank++
+	}
+	return rank, true
+}
+
+// categoryParents maps every category to its parent
+func categoryParents(db *gorm.DB) (map[int64]int64, error) {
+	var categories []models.Category
+	if err := db.Select("id", "parent_id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	parents := make(map[int64]int64, len(categories))
+	for _, c := range categories {
+		if c.ParentID != nil {
+			parents[c.ID] = *c.ParentID
+		}
+	}
+	return parents, nil
+}
+
+// categoryDistances maps the category and each of its ancestors to how many levels up it is
+func categoryDistances(parents map[int64]int64, categoryID int64) map[int64]int {
+	distances := make(map[int64]int)
+	for id, d := categoryID, 0; id != 0; id, d = parents[id], d+1 {
+		if _, seen := distances[id]; seen {
+			break // cycle left by manual edits
+		}
+		distances[id] = d
+	}
+	return distances
+}
+
+// taxTotals returns the sum of all taxes and of the taxes added on top of the prices
+func taxTotals(lines []models.TaxLine) (total, added models.Money) {
+	for _, line := range lines {
+		total += line.Amount
+		if line.Mode == models.TaxExclusive {
+			added += line.Amount
+		}
+	}
+	return total, added
+}
+
+// shippingAddress returns the user's address with the given ID, or their default
+// address when addressID is nil. It is nil when the user has no default address.
+func shippingAddress(db *gorm.DB, userID int64, addressID *int64) (*models.Address, error) {
+	var address models.Address
+	query := db.Where("user_id = ?", userID)
+	if addressID != nil {
+		query = query.Where("id = ?", *addressID)
+	} else {
+		query = query.Where("is_default = ?", true)
+	}
+	err := query.First(&address).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if addressID != nil {
+			return nil, errAddressNotFound
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// requestAddress is the shipping address chosen with ?address_id=, else the default one
+func requestAddress(db *gorm.DB, r *http.Request, userID int64) (*models.Address, error) {
+	var addressID *int64
+	if s := r.URL.Query().Get("address_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errAddressNotFound
+		}
+		addressID = &id
+	}
+	return shippingAddress(db, userID, addressID)
+}
+
+// writeAddressError reports a requestAddress failure
+func writeAddressError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAddressNotFound) {
+		http.Error(w, "Address not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+type taxRuleRequest struct {
+	Name       string         `json:"name"`
+	Country    string         `json:"country"`
+	State      string         `json:"state"`
+	Target     string         `json:"target"`
+	CategoryID *int64         `json:"category_id,omitempty"`
+	Rate       models.Percent `json:"rate"`
+	Mode       string         `json:"mode"`
+	Active     *bool          `json:"active,omitempty"`
+}
+
+// normalizeTaxRule trims and defaults the request, then checks it
+func normalizeTaxRule(req *taxRuleRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Country = strings.ToUpper(strings.TrimSpace(req.Country))
+	req.State = strings.TrimSpace(req.State)
+	if req.Target == "" {
+		req.Target = models.TaxTargetProducts
+	}
+	if req.Mode == "" {
+		req.Mode = models.TaxExclusive
+	}
+
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !isoCountries[req.Country] {
+		return fmt.Errorf("country must be an ISO 3166-1 alpha-2 code")
+	}
+	if req.Target != models.TaxTargetProducts && req.Target != models.TaxTargetLenses {
+		return fmt.Errorf("target must be products or lenses")
+	}
+	if req.Target == models.TaxTargetLenses && req.CategoryID != nil {
+		return fmt.Errorf("lens rules cannot have a category")
+	}
+	if req.Mode != models.TaxExclusive && req.Mode != models.TaxInclusive {
+		return fmt.Errorf("mode must be exclusive or inclusive")
+	}
+	return nil
+}
+
+func applyTaxRuleRequest(rule *models.TaxRule, req taxRuleRequest) {
+	rule.Name = req.Name
+	rule.Country = req.Country
+	rule.State = req.State
+	rule.Target = req.Target
+	rule.CategoryID = req.CategoryID
+	rule.Rate = req.Rate
+	rule.Mode = req.Mode
+	if req.Active != nil {
+		rule.Active = *req.Active
+	}
+}
+
+// decodeTaxRuleRequest reads and validates a tax rule, writing the error response on failure
+func decodeTaxRuleRequest(db *gorm.DB, w http.ResponseWriter, r *http.Request) (taxRuleRequest, bool) {
+	var req taxRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if err := normalizeTaxRule(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	if req.CategoryID != nil {
+		if err := categoryExists(db, *req.CategoryID); err != nil {
+			if errors.Is(err, errCategoryNotFound) {
+				http.Error(w, "Category not found", http.StatusBadRequest)
+			} else {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// GetTaxRules lists the tax rules, optionally for one ?country=
+func GetTaxRules(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := db.Order("country, state, name, id")
+		if country := r.URL.Query().Get("country"); country != "" {
+			query = query.Where("country = ?", strings.ToUpper(country))
+		}
+
+		rules := []models.TaxRule{}
+		if err := query.Find(&rules).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	}
+}
+
+// CreateTaxRule adds a tax rule
+func CreateTaxRule(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeTaxRuleRequest(db, w, r)
+		if !ok {
+			return
+		}
+
+		rule := models.TaxRule{Active: true}
+		applyTaxRuleRequest(&rule, req)
+		if err := db.Create(&rule).Error; err != nil {
+			http.Error(w, "Failed to create tax rule", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+// UpdateTaxRule replaces a tax rule. Placed orders keep the taxes they were charged.
+func UpdateTaxRule(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ruleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid tax rule ID", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodeTaxRuleRequest(db, w, r)
+		if !ok {
+			return
+		}
+
+		var rule models.TaxRule
+		if err := db.First(&rule, ruleID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Tax rule not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		applyTaxRuleRequest(&rule, req)
+		if err := db.Save(&rule).Error; err != nil {
+			http.Error(w, "Failed to update tax rule", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+// DeleteTaxRule removes a tax rule
+func DeleteTaxRule(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ruleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid tax rule ID", http.StatusBadRequest)
+			return
+		}
+
+		result := db.Delete(&models.TaxRule{}, ruleID)
+		if result.Error != nil {
+			http.Error(w, "Failed to delete tax rule", http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected == 0 {
+			http.Error(w, "Tax rule not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"backend-optical-store/models"
+	"reflect"
+	"testing"
+)
+
+func TestCategoryDistances(t *testing.T) {
+	// 1 Frames > 2 Sunglasses > 3 Kids sunglasses; 4 and 5 form a cycle
+	parents := map[int64]int64{2: 1, 3: 2, 4: 5, 5: 4}
+
+	tests := []struct {
+		category int64
+		want     map[int64]int
+	}{
+		{3, map[int64]int{3: 0, 2: 1, 1: 2}},
+		{1, map[int64]int{1: 0}},
+		{4, map[int64]int{4: 0, 5: 1}},
+		{0, map[int64]int{}},
+	}
+	for _, tt := range tests {
+		if got := categoryDistances(parents, tt.category); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("categoryDistances(%d) = %v, want %v", tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestTaxRuleRank(t *testing.T) {
+	sunglasses, frames, lenses := int64(2), int64(1), int64(9)
+	parents := map[int64]int64{2: 1, 3: 2}
+	part := taxablePart{target: models.TaxTargetProducts, categoryID: 3, amount: 100_00}
+	distances := categoryDistances(parents, part.categoryID)
+
+	tests := []struct {
+		name   string
+		rule   models.TaxRule
+		want   int
+		wantOK bool
+	}{
+		{"parent category in state", models.TaxRule{Target: models.TaxTargetProducts, State: "SP", CategoryID: &sunglasses}, 2, true},
+		{"parent category country-wide", models.TaxRule{Target: models.TaxTargetProducts, CategoryID: &sunglasses}, 3, true},
+		{"grandparent category", models.TaxRule{Target: models.TaxTargetProducts, State: "SP", CategoryID: &frames}, 4, true},
+		{"any category in state", models.TaxRule{Target: models.TaxTargetProducts, State: "SP"}, 2 * anyCategory, true},
+		{"any category country-wide", models.TaxRule{Target: models.TaxTargetProducts}, 2*anyCategory + 1, true},
+		{"unrelated category", models.TaxRule{Target: models.TaxTargetProducts, CategoryID: &lenses}, 0, false},
+		{"other target", models.TaxRule{Target: models.TaxTargetLenses}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := taxRuleRank(&tt.rule, part, distances)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("%s: taxRuleRank = %d, %v; want %d, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	// A category match anywhere up the tree beats a state rule without a category
+	nearest, _ := taxRuleRank(&tests[2].rule, part, distances)
+	broad, _ := taxRuleRank(&tests[3].rule, part, distances)
+	if nearest >= broad {
+		t.Errorf("category rule rank %d should beat category-less rank %d", nearest, broad)
+	}
+}
+
+func TestTaxTotals(t *testing.T) {
+	lines := []models.TaxLine{
+		{Name: "ICMS", Mode: models.TaxInclusive, Amount: 15_25},
+		{Name: "Sales tax", Mode: models.TaxExclusive, Amount: 8_00},
+		{Name: "City", Mode: models.TaxExclusive, Amount: 1_05},
+	}
+	total, added := taxTotals(lines)
+	if total != 24_30 || added != 9_05 {
+		t.Errorf("taxTotals = %s, %s; want 24.30, 9.05", total, added)
+	}
+	if total, added := taxTotals(nil); total != 0 || added != 0 {
+		t.Errorf("taxTotals(nil) = %s, %s; want 0", total, added)
+	}
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"backend-optical-store/middleware"
+	"backend-optical-store/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+var errDuplicateSKU = errors.New("SKU already exists")
+
+// GetVariants lists the variants of a product
+func GetVariants(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid product ID", http.StatusBadRequest)
+			return
+		}
+
+		var count int64
+		if err := db.Model(&models.Product{}).Where("id = ?", productID).Count(&count).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+
+		variants := []models.Variant{}
+		if err := db.Where("product_id = ?", productID).Order("id").Find(&variants).Error; err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(variants)
+	}
+}
+
+// CreateVariant adds a SKU to a product
+func CreateVariant(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid product ID", http.StatusBadRequest)
+			return
+		}
+
+		var product models.Product
+		if err := db.First(&product, productID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Product not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Parse multipart form
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		variant := models.Variant{ProductID: productID}
+		if err := applyVariantForm(r, &variant); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stockQty, err := strconv.Atoi(r.FormValue("stock_qty"))
+		if err != nil || stockQty < 0 {
+			http.Error(w, "Valid stock quantity is required", http.StatusBadRequest)
+			return
+		}
+		variant.StockQty = stockQty
+
+		if err := checkUniqueSKU(db, variant.SKU, 0); err != nil {
+			writeVariantError(w, err, "Failed to create variant")
+			return
+		}
+
+		// Handle file upload
+		file, _, err := r.FormFile("image")
+		if err == nil {
+			defer file.Close()
+
+			variant.ImageURL, err = saveUpload(file, variant.SKU)
+			if err != nil {
+				writeUploadError(w, err)
+				return
+			}
+		}
+
+		// The variant starts empty; its opening stock is booked as a receipt
+		openingQty := variant.StockQty
+		variant.StockQty = 0
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Omit("Product").Create(&variant).Error; err != nil {
+				return err
+			}
+			if openingQty == 0 {
+				return nil
+			}
+			if err := recordMovement(tx, &models.InventoryMovement{
+				VariantID: variant.ID,
+				Kind:      models.MovementReceipt,
+				QtyDelta:  openingQty,
+				UserID:    &userID,
+				Reason:    "opening stock",
+			}); err != nil {
+				return err
+			}
+			variant.StockQty = openingQty
+			return nil
+		})
+		if err != nil {
+			removeUpload(variant.ImageURL)
+			writeVariantError(w, err, "Failed to create variant")
+			return
+		}
+		reindexProducts(db, productID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(variant)
+	}
+}
+
+// UpdateVariant edits a product's variant, optionally replacing its image
+func UpdateVariant(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		variant, ok := findProductVariant(db, w, r)
+		if !ok {
+			return
+		}
+
+		// Parse multipart form
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+
+		if err := applyVariantForm(r, &variant); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := checkUniqueSKU(db, variant.SKU, variant.ID); err != nil {
+			writeVariantError(w, err, "Failed to update variant")
+			return
+		}
+
+		// Handle file upload if provided; the old image is only deleted once the
+		// variant points at the new one
+		oldImage := variant.ImageURL
+		file, _, err := r.FormFile("image")
+		if err == nil {
+			defer file.Close()
+
+			variant.ImageURL, err = saveUpload(file, variant.SKU)
+			if err != nil {
+				writeUploadError(w, err)
+				return
+			}
+		}
+
+		// Stock only changes through the inventory ledger
+		if err := db.Omit("Product", "StockQty").Save(&variant).Error; err != nil {
+			if variant.ImageURL != oldImage {
+				removeUpload(variant.ImageURL)
+			}
+			writeVariantError(w, err, "Failed to update variant")
+			return
+		}
+		if variant.ImageURL != oldImage {
+			removeUpload(oldImage)
+		}
+		reindexProducts(db, variant.ProductID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(variant)
+	}
+}
+
+// DeleteVariant removes a variant that no active cart is holding
+func DeleteVariant(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		variant, ok := findProductVariant(db, w, r)
+		if !ok {
+			return
+		}
+
+		// Refuse while customers still have it in their carts
+		var inCarts int64
+		err := db.Model(&models.CartItem{}).
+			Joins("JOIN carts ON carts.id = cart_items.cart_id").
+			Where("cart_items.product_variant_id = ? AND carts.status = ?", variant.ID, "active").
+			Count(&inCarts).Error
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if inCarts > 0 {
+			http.Error(w, "Variant is in active carts and cannot be deleted", http.StatusConflict)
+			return
+		}
+
+		if err := db.Delete(&variant).Error; err != nil {
+			http.Error(w, "Failed to delete variant", http.StatusInternalServerError)
+			return
+		}
+
+		// Delete associated image file if it exists
+		removeUpload(variant.ImageURL)
+		reindexProducts(db, variant.ProductID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// findProductVariant loads the variant named in the URL, scoped to the product in the URL
+func findProductVariant(db *gorm.DB, w http.ResponseWriter, r *http.Request) (models.Variant, bool) {
+	var variant models.Variant
+
+	productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return variant, false
+	}
+	variantID, err := strconv.ParseInt(chi.URLParam(r, "variantID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		return variant, false
+	}
+
+	if err := db.Where("id = ? AND product_id = ?", variantID, productID).First(&variant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Variant not found", http.StatusNotFound)
+			return variant, false
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return variant, false
+	}
+	return variant, true
+}
+
+// applyVariantForm validates the variant form fields and copies them onto variant
+func applyVariantForm(r *http.Request, variant *models.Variant) error {
+	sku := strings.TrimSpace(r.FormValue("sku"))
+	if sku == "" || len(sku) > 64 {
+		return errors.New("SKU is required (max 64 characters)")
+	}
+
+	var extraPrice models.Money
+	if s := r.FormValue("extra_price"); s != "" {
+		v, err := models.ParseMoney(s)
+		if err != nil || v < 0 {
+			return errors.New("Valid extra price is required")
+		}
+		extraPrice = v
+	}
+
+	reorderPoint := 0
+	if s := r.FormValue("reorder_point"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return errors.New("Valid reorder point is required")
+		}
+		reorderPoint = v
+	}
+
+	variant.SKU = sku
+	variant.Color = strings.TrimSpace(r.FormValue("color"))
+	variant.Size = strings.TrimSpace(r.FormValue("size"))
+	variant.ExtraPrice = extraPrice
+	variant.ReorderPoint = reorderPoint
+	return nil
+}
+
+// checkUniqueSKU returns errDuplicateSKU when another variant already uses sku
+func checkUniqueSKU(db *gorm.DB, sku string, exceptID int64) error {
+	var count int64
+	if err := db.Model(&models.Variant{}).Where("sku = ? AND id <> ?", sku, exceptID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errDuplicateSKU
+	}
+	return nil
+}
+
+// writeVariantError reports SKU conflicts, including ones caught by the unique index
+func writeVariantError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, errDuplicateSKU) || strings.Contains(err.Error(), "Duplicate entry") {
+		http.Error(w, errDuplicateSKU.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend-optical-store/models"
+)
+
+// StartReservationSweeper releases expired stock reservations every interval until ctx is done.
+// Expired reservations already stop counting against stock; sweeping keeps the table tidy
+// and the reservation history accurate.
+func StartReservationSweeper(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				result := db.Model(&models.StockReservation{}).
+					Where("status = ? AND expires_at <= ?", models.ReservationActive, now).
+					Update("status", models.ReservationReleased)
+				if result.Error != nil {
+					log.Printf("Error releasing expired reservations: %v", result.Error)
+				} else if result.RowsAffected > 0 {
+					log.Printf("Released %d expired stock reservations", result.RowsAffected)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend-optical-store/models"
+	"backend-optical-store/notify"
+)
+
+// StartLowStockMonitor checks for variants at or below their reorder point every interval
+// until ctx is done. Each variant is reported once per dip; restocking it above the
+// reorder point re-arms the alert.
+func StartLowStockMonitor(ctx context.Context, db *gorm.DB, notifier notify.Notifier, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := checkLowStock(ctx, db, notifier, now); err != nil {
+					log.Printf("Error checking low stock: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func checkLowStock(ctx context.Context, db *gorm.DB, notifier notify.Notifier, now time.Time) error {
+	// Re-arm variants that were restocked
+	if err := db.Model(&models.Variant{}).
+		Where("low_stock_alerted_at IS NOT NULL AND stock_qty > reorder_point").
+		Update("low_stock_alerted_at", nil).Error; err != nil {
+		return err
+	}
+
+	var variants []models.Variant
+	if err := db.Scopes(models.LowStock).Preload("Product").
+		Where("low_stock_alerted_at IS NULL").
+		Order("stock_qty").Find(&variants).Error; err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+
+	var body strings.Builder
+	ids := make([]int64, 0, len(variants))
+	for _, v := range variants {
+		fmt.Fprintf(&body, "%s (%s %s %s): %d left, reorder point %d\n",
+			v.SKU, v.Product.Name, v.Color, v.Size, v.StockQty, v.ReorderPoint)
+		ids = append(ids, v.ID)
+	}
+	subject := fmt.Sprintf("%d variants at or below their reorder point", len(variants))
+
+	// Only mark variants as alerted once the alert actually went out
+	if err := notifier.Notify(ctx, subject, body.String()); err != nil {
+		return err
+	}
+	return db.Model(&models.Variant{}).Where("id IN ?", ids).Update("low_stock_alerted_at", now).Error
+}
@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"backend-optical-store/db"
+	"backend-optical-store/jobs"
+	"backend-optical-store/middleware"
+	"backend-optical-store/notify"
 	"backend-optical-store/router"
 
 	"github.com/go-chi/chi/v5"
@@ -21,7 +24,14 @@ func main() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Println("Warning: Error loading .env file, using default environment variables")
-	} // Connect to database
+	}
+
+	// Load JWT signing keys before any token can be issued or verified
+	if err := middleware.LoadKeys(); err != nil {
+		log.Fatal("JWT configuration error:", err)
+	}
+
+	// Connect to database
 	db.ConnectDB()
 
 	// Create a new router and apply middleware before adding routes
@@ -48,6 +58,12 @@ func main() {
 	// Now mount all routes from router package
 	r.Mount("/", router.New(db.DB))
 
+	// Background jobs stop with the server
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobs.StartReservationSweeper(jobsCtx, db.DB, time.Minute)
+	jobs.StartLowStockMonitor(jobsCtx, db.DB, notify.FromEnv(), 15*time.Minute)
+
 	// Get port from environment variable or use default
 	port := os.Getenv("PORT")
 	if port == "" {
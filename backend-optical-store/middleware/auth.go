@@ -12,11 +12,15 @@ import (
 var (
 	ErrNoToken      = errors.New("no token provided")
 	ErrInvalidToken = errors.New("invalid token")
+	ErrForbidden    = errors.New("forbidden")
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey contextKey = "userID"
+	RoleKey   contextKey = "role"
+)
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -33,13 +37,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		token, err := jwt.Parse(tokenParts[1], func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, ErrInvalidToken
-			}
-			return []byte(GetJWTSecret()), nil
-		})
-
+		token, err := ParseToken(tokenParts[1])
 		if err != nil || !token.Valid {
 			http.Error(w, ErrInvalidToken.Error(), http.StatusUnauthorized)
 			return
@@ -57,13 +55,29 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Add userID to request context
+		// Tokens issued before roles were added carry no role claim
+		role, _ := claims["role"].(string)
+
+		// Add userID and role to request context
 		ctx := context.WithValue(r.Context(), UserIDKey, int64(userID))
+		ctx = context.WithValue(ctx, RoleKey, role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func GetJWTSecret() string {
-	// TODO: Load from environment variable
-	return "your-secret-key"
+// RequireRole only lets through requests whose token carries one of the given roles.
+// It must run after AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value(RoleKey).(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		})
+	}
 }
@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKey is returned when tokens are signed before LoadKeys has run
+var ErrNoSigningKey = errors.New("jwt signing key not loaded")
+
+// signingKey is a single JWT key, identified in token headers by its kid
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{} // nil for keys that are only kept for verification
+	verifyKey interface{}
+}
+
+var (
+	currentKey  *signingKey
+	previousKey *signingKey
+)
+
+// LoadKeys reads the JWT signing configuration from the environment.
+//
+//	JWT_ALG                       HS256 (default), RS256 or EdDSA
+//	JWT_SECRET / JWT_SECRET_FILE  HMAC secret for HS256
+//	JWT_PRIVATE_KEY_FILE          PEM private key for RS256/EdDSA
+//	JWT_KID                       optional key id, derived from the key when empty
+//
+// The key being rotated out is configured the same way with a JWT_PREVIOUS_
+// prefix (JWT_PREVIOUS_ALG, JWT_PREVIOUS_SECRET(_FILE), JWT_PREVIOUS_PUBLIC_KEY_FILE,
+// JWT_PREVIOUS_KID). Tokens signed with it are still accepted but never issued.
+func LoadKeys() error {
+	alg := envOrDefault("JWT_ALG", "HS256")
+	key, err := loadKey("JWT_", alg, true)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		// Only development may run without a configured key; tokens won't survive a restart
+		if os.Getenv("APP_ENV") != "development" {
+			return errors.New("JWT_SECRET or JWT_PRIVATE_KEY_FILE must be set")
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		log.Println("Warning: no JWT key configured, using a random development secret")
+		key = &signingKey{id: secretKeyID(secret), method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+	}
+
+	prev, err := loadKey("JWT_PREVIOUS_", envOrDefault("JWT_PREVIOUS_ALG", alg), false)
+	if err != nil {
+		return err
+	}
+	if prev != nil && prev.id == key.id {
+		return errors.New("current and previous JWT keys share the same kid")
+	}
+
+	currentKey, previousKey = key, prev
+	return nil
+}
+
+// SignToken signs claims with the current key and tags the token with its kid
+func SignToken(claims jwt.Claims) (string, error) {
+	if currentKey == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(currentKey.method, claims)
+	token.Header["kid"] = currentKey.id
+	return token.SignedString(currentKey.signKey)
+}
+
+// ParseToken verifies a token against the current or previous key, chosen by its kid
+func ParseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		key := currentKey
+		if kid, _ := token.Header["kid"].(string); previousKey != nil && kid == previousKey.id {
+			key = previousKey
+		} else if key == nil || kid != key.id {
+			return nil, ErrInvalidToken
+		}
+
+		// Never let the token header pick a different algorithm than the key's
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return key.verifyKey, nil
+	})
+}
+
+// loadKey reads one key using the variables under prefix. It returns nil when none are set.
+// Keys that are only used for verification may be given as public keys.
+func loadKey(prefix, alg string, signing bool) (*signingKey, error) {
+	var key *signingKey
+
+	switch alg {
+	case "HS256":
+		secret, err := envOrFile(prefix+"SECRET", prefix+"SECRET_FILE")
+		if err != nil || secret == nil {
+			return nil, err
+		}
+		key = &signingKey{id: secretKeyID(secret), method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+
+	case "RS256", "EdDSA":
+		file := prefix + "PRIVATE_KEY_FILE"
+		if !signing {
+			file = prefix + "PUBLIC_KEY_FILE"
+		}
+		path := os.Getenv(file)
+		if path == "" {
+			return nil, nil
+		}
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file, err)
+		}
+
+		key = &signingKey{method: jwt.GetSigningMethod(alg)}
+		var public crypto.PublicKey
+		switch {
+		case alg == "RS256" && signing:
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", file, err)
+			}
+			key.signKey, public = private, &private.PublicKey
+		case alg == "RS256":
+			public, err = jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+		case signing:
+			var private crypto.PrivateKey
+			private, err = jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+			if err == nil {
+				key.signKey, public = private, private.(crypto.Signer).Public()
+			}
+		default:
+			public, err = jwt.ParseEdPublicKeyFromPEM(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		key.id, key.verifyKey = fingerprint(der), public
+
+	default:
+		return nil, fmt.Errorf("unsupported %sALG %q", prefix, alg)
+	}
+
+	if kid := os.Getenv(prefix + "KID"); kid != "" {
+		key.id = kid
+	}
+	return key, nil
+}
+
+// envOrFile returns the value of the variable name, or the contents of the file named by fileVar
+func envOrFile(name, fileVar string) ([]byte, error) {
+	if value := os.Getenv(name); value != "" {
+		return []byte(value), nil
+	}
+	path := os.Getenv(fileVar)
+	if path == "" {
+		return nil, nil
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", fileVar, err)
+	}
+	return []byte(strings.TrimSpace(string(contents))), nil
+}
+
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// fingerprint derives a stable key id from public key material
+func fingerprint(material []byte) string {
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+// secretKeyID derives the key id of a shared secret. A plain hash of the secret would
+// let anyone holding a token check guesses offline, so the id is a MAC of a fixed
+// label under the secret instead.
+func secretKeyID(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("optical-store jwt kid"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
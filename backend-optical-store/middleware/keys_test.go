@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyEnv is every variable LoadKeys reads
+var keyEnv = []string{
+	"APP_ENV", "JWT_ALG", "JWT_SECRET", "JWT_SECRET_FILE", "JWT_PRIVATE_KEY_FILE", "JWT_KID",
+	"JWT_PREVIOUS_ALG", "JWT_PREVIOUS_SECRET", "JWT_PREVIOUS_SECRET_FILE", "JWT_PREVIOUS_PUBLIC_KEY_FILE", "JWT_PREVIOUS_KID",
+}
+
+// loadTestKeys runs LoadKeys with only the given variables set and restores the keys afterwards
+func loadTestKeys(t *testing.T, env map[string]string) error {
+	t.Helper()
+	current, previous := currentKey, previousKey
+	t.Cleanup(func() { currentKey, previousKey = current, previous })
+	for _, name := range keyEnv {
+		t.Setenv(name, env[name])
+	}
+	return LoadKeys()
+}
+
+func signed(t *testing.T, env map[string]string) string {
+	t.Helper()
+	if err := loadTestKeys(t, env); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	token, err := SignToken(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	return token
+}
+
+func TestParseTokenKeys(t *testing.T) {
+	oldToken := signed(t, map[string]string{"JWT_SECRET": "old-secret"})
+	rotated := map[string]string{"JWT_SECRET": "new-secret", "JWT_PREVIOUS_SECRET": "old-secret"}
+	newToken := signed(t, rotated)
+	newKID := secretKeyID([]byte("new-secret"))
+
+	forge := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1"})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		token string
+		ok    bool
+	}{
+		{"current kid", rotated, newToken, true},
+		{"previous kid", rotated, oldToken, true},
+		{"previous key retired", map[string]string{"JWT_SECRET": "new-secret"}, oldToken, false},
+		{"unknown kid", rotated, forge(jwt.SigningMethodHS256, "someone-else", []byte("new-secret")), false},
+		{"missing kid", rotated, forge(jwt.SigningMethodHS256, "", []byte("new-secret")), false},
+		{"alg mismatch", rotated, forge(jwt.SigningMethodHS384, newKID, []byte("new-secret")), false},
+		{"wrong secret", rotated, forge(jwt.SigningMethodHS256, newKID, []byte("guess")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := loadTestKeys(t, tt.env); err != nil {
+				t.Fatalf("LoadKeys: %v", err)
+			}
+			token, err := ParseToken(tt.token)
+			if ok := err == nil && token.Valid; ok != tt.ok {
+				t.Errorf("ParseToken valid = %v (%v), want %v", ok, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseTokenRS256(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privateFile := filepath.Join(dir, "private.pem")
+	der := x509.MarshalPKCS1PrivateKey(private)
+	if err := os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	token := signed(t, map[string]string{"JWT_ALG": "RS256", "JWT_PRIVATE_KEY_FILE": privateFile})
+	if _, err := ParseToken(token); err != nil {
+		t.Errorf("ParseToken: %v", err)
+	}
+
+	// An HMAC token keyed with the public key must not pass as RS256
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"})
+	forged.Header["kid"] = currentKey.id
+	forgedString, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(forgedString); err == nil {
+		t.Error("ParseToken accepted an HS256 token for an RS256 key")
+	}
+}
+
+func TestLoadKeysErrors(t *testing.T) {
+	dir := t.TempDir()
+	badPEM := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(badPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"no key outside development", map[string]string{}},
+		{"unsupported alg", map[string]string{"JWT_ALG": "HS512", "JWT_SECRET": "s"}},
+		{"bad private PEM", map[string]string{"JWT_ALG": "RS256", "JWT_PRIVATE_KEY_FILE": badPEM}},
+		{"bad EdDSA PEM", map[string]string{"JWT_ALG": "EdDSA", "JWT_PRIVATE_KEY_FILE": badPEM}},
+		{"missing private key file", map[string]string{"JWT_ALG": "RS256", "JWT_PRIVATE_KEY_FILE": missing}},
+		{"missing secret file", map[string]string{"JWT_SECRET_FILE": missing}},
+		{"missing previous secret file", map[string]string{"JWT_SECRET": "s", "JWT_PREVIOUS_SECRET_FILE": missing}},
+		{"bad previous public PEM", map[string]string{"JWT_SECRET": "s", "JWT_PREVIOUS_ALG": "RS256", "JWT_PREVIOUS_PUBLIC_KEY_FILE": badPEM}},
+		{"same kid twice", map[string]string{"JWT_SECRET": "s", "JWT_PREVIOUS_SECRET": "s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := loadTestKeys(t, tt.env); err == nil {
+				t.Error("LoadKeys succeeded")
+			}
+		})
+	}
+}
+
+func TestSecretKeyID(t *testing.T) {
+	a, b := secretKeyID([]byte("secret-a")), secretKeyID([]byte("secret-b"))
+	if a != secretKeyID([]byte("secret-a")) {
+		t.Error("secretKeyID is not stable")
+	}
+	if a == b {
+		t.Error("different secrets share a kid")
+	}
+	if a == fingerprint([]byte("secret-a")) {
+		t.Error("secretKeyID is a plain hash of the secret")
+	}
+	if len(a) != 16 {
+		t.Errorf("secretKeyID length = %d, want 16", len(a))
+	}
+}
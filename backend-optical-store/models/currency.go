@@ -0,0 +1,86 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateScale is the number of Rate units in 1: rates keep six decimal places
+const RateScale = 1_000_000
+
+// Rate is an exchange rate in millionths: how much of a currency one unit of
+// the base Currency buys. A Rate of 1_000_000 is parity.
+type Rate int64
+
+// ErrInvalidRate is returned for rates that are not positive decimals with at most six places
+var ErrInvalidRate = errors.New("rate must be a positive decimal with at most six decimal places")
+
+// ParseRate parses a decimal such as "0.182345" exactly
+func ParseRate(s string) (Rate, error) {
+	units, ok := parseDecimal(s, 6)
+	if !ok || units <= 0 {
+		return 0, ErrInvalidRate
+	}
+	return Rate(units), nil
+}
+
+// Convert turns a base-currency amount into the rate's currency, rounding half away from zero
+func (r Rate) Convert(m Money) Money {
+	return m.MulRatio(int64(r), RateScale)
+}
+
+// ConvertBack turns an amount in the rate's currency into the base currency
+func (r Rate) ConvertBack(m Money) Money {
+	return m.MulRatio(RateScale, int64(r))
+}
+
+// String formats the rate with six decimal places, e.g. "0.182345"
+func (r Rate) String() string {
+	frac := strconv.FormatInt(int64(r)%RateScale, 10)
+	return strconv.FormatInt(int64(r)/RateScale, 10) + "." + strings.Repeat("0", 6-len(frac)) + frac
+}
+
+// MarshalJSON writes the rate as a JSON number with six decimal places
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a decimal string
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	v, err := ParseRate(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// ExchangeRate is the admin-maintained rate for displaying and charging prices
+// in a currency other than the base Currency
+type ExchangeRate struct {
+	ID        int64     `json:"id"`
+	Currency  string    `json:"currency" gorm:"size:3;uniqueIndex"` // ISO 4217, e.g. "USD"
+	Rate      Rate      `json:"rate"`                               // units of Currency per 1 BRL
+	UpdatedBy *int64    `json:"-"`                                  // admin who last set the rate; the list is public
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ValidCurrencyCode reports whether code looks like an ISO 4217 code: three upper-case letters
+func ValidCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package models
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+		ok   bool
+	}{
+		{"0.182345", 182_345, true},
+		{"1", RateScale, true},
+		{"5.5", 5_500_000, true},
+		{"0.000001", 1, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"0.0000001", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseRate(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRateConvert(t *testing.T) {
+	tests := []struct {
+		rate     Rate
+		base     Money // amount in the base currency
+		want     Money // converted amount
+		wantBack Money // want converted back
+	}{
+		{RateScale, 299_99, 299_99, 299_99},
+		{182_345, 100_00, 18_23, 99_98}, // 18.2345 rounds down, so the round trip is off by cents
+		{182_345, 299_90, 54_69, 299_93},
+		{5_500_000, 1_00, 5_50, 1_00},
+		{500_000, 1, 1, 2}, // half a centavo rounds away from zero
+	}
+	for _, tt := range tests {
+		got := tt.rate.Convert(tt.base)
+		if got != tt.want {
+			t.Errorf("Rate(%s).Convert(%s) = %s, want %s", tt.rate, tt.base, got, tt.want)
+		}
+		if back := tt.rate.ConvertBack(got); back != tt.wantBack {
+			t.Errorf("Rate(%s).ConvertBack(%s) = %s, want %s", tt.rate, got, back, tt.wantBack)
+		}
+	}
+}
+
+func TestRateString(t *testing.T) {
+	tests := []struct {
+		rate Rate
+		want string
+	}{
+		{RateScale, "1.000000"},
+		{182_345, "0.182345"},
+		{1, "0.000001"},
+		{5_500_000, "5.500000"},
+	}
+	for _, tt := range tests {
+		if got := tt.rate.String(); got != tt.want {
+			t.Errorf("Rate(%d).String() = %q, want %q", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestValidCurrencyCode(t *testing.T) {
+	tests := map[string]bool{
+		"USD":  true,
+		"EUR":  true,
+		"usd":  false,
+		"US":   false,
+		"USDT": false,
+		"U$D":  false,
+		"":     false,
+	}
+	for code, want := range tests {
+		if got := ValidCurrencyCode(code); got != want {
+			t.Errorf("ValidCurrencyCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
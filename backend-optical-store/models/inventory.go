@@ -0,0 +1,48 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Inventory movement kinds
+const (
+	MovementReceipt    = "receipt"    // stock received from a supplier
+	MovementSale       = "sale"       // stock taken by an order
+	MovementReturn     = "return"     // stock back on the shelf, e.g. a cancelled order
+	MovementAdjustment = "adjustment" // stock count correction, signed
+	MovementDamage     = "damage"     // stock written off
+)
+
+// ErrLedgerAppendOnly is returned when something tries to rewrite inventory history
+var ErrLedgerAppendOnly = errors.New("inventory movements are append-only")
+
+// InventoryMovement is one entry in the append-only inventory ledger.
+// A variant's StockQty always equals the sum of its movements' QtyDelta.
+type InventoryMovement struct {
+	ID        int64     `json:"id"`
+	VariantID int64     `json:"variant_id" gorm:"index"`
+	Kind      string    `json:"kind" gorm:"size:16"`
+	QtyDelta  int       `json:"qty_delta"`
+	UserID    *int64    `json:"user_id,omitempty"` // who posted it, nil for system movements
+	OrderID   *int64    `json:"order_id,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// BeforeUpdate keeps ledger entries immutable
+func (m *InventoryMovement) BeforeUpdate(tx *gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
+
+// BeforeDelete keeps ledger entries immutable
+func (m *InventoryMovement) BeforeDelete(tx *gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
+
+// LowStock scopes a variants query to variants at or below their reorder point
+func LowStock(db *gorm.DB) *gorm.DB {
+	return db.Where("variants.reorder_point > 0 AND variants.stock_qty <= variants.reorder_point")
+}
@@ -0,0 +1,49 @@
+package models
+
+import "sort"
+
+// Lens option kinds
+const (
+	LensKindType    = "type"    // single_vision, progressive, bifocal
+	LensKindIndex   = "index"   // 1.50, 1.60, 1.67, 1.74
+	LensKindCoating = "coating" // anti_reflective, blue_light, photochromic
+)
+
+// LensOption is a selectable lens choice priced on top of the frame
+type LensOption struct {
+	ID         int64  `json:"id"`
+	Kind       string `json:"kind" gorm:"size:16;uniqueIndex:idx_lens_options_kind_code"`
+	Code       string `json:"code" gorm:"size:32;uniqueIndex:idx_lens_options_kind_code"`
+	Name       string `json:"name"`
+	PriceDelta Money  `json:"price_delta"`
+	Active     bool   `json:"active" gorm:"default:true"`
+}
+
+// LensOptions is the lens configuration chosen for a frame on a cart or order line.
+// Lines without lenses (sunglasses, cases, frame only) carry nil options.
+type LensOptions struct {
+	Type     string   `json:"type"`
+	Index    string   `json:"index"`
+	Coatings []string `json:"coatings,omitempty"`
+}
+
+// NeedsNearAddition reports whether the lens type corrects near vision and so needs an ADD value
+func (o *LensOptions) NeedsNearAddition() bool {
+	return o.Type == "progressive" || o.Type == "bifocal"
+}
+
+// Normalize sorts and de-duplicates coatings so equal configurations serialize identically
+func (o *LensOptions) Normalize() {
+	if len(o.Coatings) == 0 {
+		o.Coatings = nil
+		return
+	}
+	sort.Strings(o.Coatings)
+	unique := o.Coatings[:1]
+	for _, c := range o.Coatings[1:] {
+		if c != unique[len(unique)-1] {
+			unique = append(unique, c)
+		}
+	}
+	o.Coatings = unique
+}
@@ -2,13 +2,22 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// User roles
+const (
+	RoleCustomer = "customer"
+	RoleOptician = "optician"
+	RoleAdmin    = "admin"
 )
 
 type User struct {
 	ID           int64     `json:"id"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"`
+	Role         string    `json:"role" gorm:"default:customer"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	Addresses    []Address `json:"addresses" gorm:"foreignKey:UserID"`
@@ -30,55 +39,94 @@ type Address struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// Prescription review statuses
+const (
+	PrescriptionPending  = "pending"
+	PrescriptionVerified = "verified"
+	PrescriptionRejected = "rejected"
+)
+
+// Prescription is an uploaded eyeglass prescription reviewed by an optician.
+// Files live outside the public uploads directory and are served through the API.
 type Prescription struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	FileURL   string    `json:"file_url"`
-	IssuedAt  time.Time `json:"issued_at"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Verified  bool      `json:"verified"`
+	ID              int64              `json:"id"`
+	UserID          int64              `json:"user_id"`
+	FileURL         string             `json:"file_url"`
+	IssuedAt        time.Time          `json:"issued_at"`
+	ExpiresAt       time.Time          `json:"expires_at"`
+	Verified        bool               `json:"verified"`
+	Values          PrescriptionValues `json:"values" gorm:"embedded"`        // structured lens data, see optics.go
+	Status          string             `json:"status" gorm:"default:pending"` // pending, verified, rejected
+	RejectionReason string             `json:"rejection_reason,omitempty"`
+	ReviewedBy      *int64             `json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time         `json:"reviewed_at,omitempty"`
+	Expired         bool               `json:"expired" gorm:"-"` // computed on load
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+}
+
+// IsExpired reports whether the prescription is past its expiry date at t
+func (p *Prescription) IsExpired(t time.Time) bool {
+	return !p.ExpiresAt.After(t)
+}
+
+// AfterFind flags expired prescriptions whenever they are loaded
+func (p *Prescription) AfterFind(tx *gorm.DB) error {
+	p.Expired = p.IsExpired(time.Now())
+	return nil
 }
 
 type Product struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	BasePrice   float64   `json:"base_price"`
-	CategoryID  int64     `json:"category_id"`
-	Image       string    `json:"image"`
-	Variants    []Variant `json:"variants" gorm:"foreignKey:ProductID"`
+	ID          int64  `json:"id"`
+	Name        string `json:"name" gorm:"index:ft_products_name,class:FULLTEXT"`
+	Description string `json:"description"`
+	BasePrice   Money  `json:"base_price"` // in the base Currency, like the variants' ExtraPrice
+	CategoryID  int64  `json:"category_id"`
+	Image       string `json:"image"`
+	// AcceptsLenses marks frames that can be fitted with lenses (not sunglasses or cases)
+	AcceptsLenses bool `json:"accepts_lenses"`
+	// SearchDocument is the text the search index sees, maintained by the search package
+	SearchDocument string    `json:"-" gorm:"type:text;index:ft_products_search,class:FULLTEXT"`
+	Variants       []Variant `json:"variants" gorm:"foreignKey:ProductID"`
 }
 
 type Variant struct {
-	ID         int64   `json:"id"`
-	ProductID  int64   `json:"product_id"`
-	SKU        string  `json:"sku"`
-	Color      string  `json:"color"`
-	Size       string  `json:"size"`
-	ExtraPrice float64 `json:"extra_price"`
-	StockQty   int     `json:"stock_qty"`
-	ImageURL   string  `json:"image_url"`
-	Product    Product `json:"product" gorm:"foreignKey:ProductID;references:ID"`
+	ID         int64  `json:"id"`
+	ProductID  int64  `json:"product_id"`
+	SKU        string `json:"sku" gorm:"size:64;uniqueIndex"`
+	Color      string `json:"color"`
+	Size       string `json:"size"`
+	ExtraPrice Money  `json:"extra_price"`
+	StockQty   int    `json:"stock_qty"`
+	// ReorderPoint is the stock level at which the variant needs restocking; 0 disables alerts
+	ReorderPoint      int        `json:"reorder_point"`
+	LowStockAlertedAt *time.Time `json:"-"` // set once an alert went out, cleared when restocked
+	ImageURL          string     `json:"image_url"`
+	Product           Product    `json:"product" gorm:"foreignKey:ProductID;references:ID"`
 }
 
 type Cart struct {
-	ID        int64      `json:"id"`
-	UserID    int64      `json:"user_id"`
-	Status    string     `json:"status"` // active, converted
-	Items     []CartItem `json:"items" gorm:"foreignKey:CartID"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Status string `json:"status"` // active, converted
+	// CouponCode is the coupon the customer entered, checked again on every read
+	CouponCode string     `json:"coupon_code" gorm:"size:64"`
+	Items      []CartItem `json:"items" gorm:"foreignKey:CartID"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 }
 
 type CartItem struct {
-	ID               int64     `json:"id"`
-	CartID           int64     `json:"cart_id"`
-	ProductVariantID int64     `json:"product_variant_id"`
-	Qty              int       `json:"qty"`
-	UnitPrice        float64   `json:"unit_price"`
-	Variant          Variant   `json:"variant" gorm:"foreignKey:ProductVariantID;references:ID"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               int64        `json:"id"`
+	CartID           int64        `json:"cart_id"`
+	ProductVariantID int64        `json:"product_variant_id"`
+	Qty              int          `json:"qty"`
+	UnitPrice        Money        `json:"unit_price"` // frame plus lens price
+	LensOptions      *LensOptions `json:"lens_options,omitempty" gorm:"column:lens_options_json;serializer:json"`
+	LensPrice        Money        `json:"lens_price"`
+	Variant          Variant      `json:"variant" gorm:"foreignKey:ProductVariantID;references:ID"`
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
 }
 
 type Category struct {
@@ -91,24 +139,99 @@ type Category struct {
 }
 
 type Order struct {
-	ID             int64       `json:"id"`
-	UserID         int64       `json:"user_id"`
-	PrescriptionID int64       `json:"prescription_id"`
-	Status         string      `json:"status"`
-	Total          float64     `json:"total"`
-	PlacedAt       time.Time   `json:"placed_at"`
-	PaidAt         *time.Time  `json:"paid_at"`
-	ShippedAt      *time.Time  `json:"shipped_at"`
-	Items          []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
+	ID             int64         `json:"id"`
+	UserID         int64         `json:"user_id"`
+	PrescriptionID *int64        `json:"prescription_id"`
+	Prescription   *Prescription `json:"prescription,omitempty" gorm:"foreignKey:PrescriptionID"`
+	Status         string        `json:"status"`     // see order_status.go
+	AddressID      *int64        `json:"address_id"` // shipping address the taxes were calculated for
+	// ShippingAddress is a copy of the address at checkout, so editing or deleting
+	// the address later doesn't change where the order went or what it was taxed for
+	ShippingAddress OrderAddress    `json:"shipping_address" gorm:"embedded;embeddedPrefix:shipping_"`
+	Subtotal        Money           `json:"subtotal"` // sum of the lines, in the base Currency
+	Discounts       []OrderDiscount `json:"discounts" gorm:"foreignKey:OrderID"`
+	DiscountTotal   Money           `json:"discount_total"`
+	Shipping        Money           `json:"shipping"`  // shipping fee; a free shipping discount offsets it
+	TaxTotal        Money           `json:"tax_total"` // all taxes, including those contained in the prices
+	Taxes           []OrderTax      `json:"taxes" gorm:"foreignKey:OrderID"`
+	Total           Money           `json:"total"`                              // grand total in the base Currency
+	Currency        string          `json:"currency" gorm:"size:3;default:BRL"` // currency the customer was charged in
+	ExchangeRate    Rate            `json:"exchange_rate"`                      // Currency per base unit at placement
+	ChargedTotal    Money           `json:"charged_total"`                      // amount charged, in Currency
+	PlacedAt        time.Time       `json:"placed_at"`
+	PaidAt          *time.Time      `json:"paid_at"`
+	InLabAt         *time.Time      `json:"in_lab_at"`
+	ShippedAt       *time.Time      `json:"shipped_at"`
+	DeliveredAt     *time.Time      `json:"delivered_at"`
+	CancelledAt     *time.Time      `json:"cancelled_at"`
+	RefundedAt      *time.Time      `json:"refunded_at"`
+	Items           []OrderItem     `json:"items" gorm:"foreignKey:OrderID"`
+}
+
+// OrderAddress is the shipping address recorded on an order; empty for orders placed without one
+type OrderAddress struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code" gorm:"size:32"`
+	Country    string `json:"country" gorm:"size:2"`
+}
+
+// NewOrderAddress copies an address onto an order
+func NewOrderAddress(a Address) OrderAddress {
+	snapshot := OrderAddress{
+		Name:       a.Name,
+		Line1:      a.Line1,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+	if a.Line2 != nil {
+		snapshot.Line2 = *a.Line2
+	}
+	return snapshot
 }
 
 type OrderItem struct {
-	ID               int64   `json:"id"`
-	OrderID          int64   `json:"order_id"`
-	ProductVariantID int64   `json:"product_variant_id"`
-	Qty              int     `json:"qty"`
-	UnitPrice        float64 `json:"unit_price"`
-	LensOptionsJSON  string  `json:"lens_options"`
+	ID               int64        `json:"id"`
+	OrderID          int64        `json:"order_id"`
+	ProductVariantID int64        `json:"product_variant_id"`
+	Qty              int          `json:"qty"`
+	UnitPrice        Money        `json:"unit_price"` // frame plus lens price
+	LensOptions      *LensOptions `json:"lens_options,omitempty" gorm:"column:lens_options_json;serializer:json"`
+	LensPrice        Money        `json:"lens_price"`
+
+	// Snapshot of the variant and product at the time the order was placed,
+	// so later catalog edits don't rewrite order history
+	ProductID   int64  `json:"product_id" gorm:"index"`
+	ProductName string `json:"product_name"`
+	SKU         string `json:"sku"`
+	Color       string `json:"color"`
+	Size        string `json:"size"`
+	Image       string `json:"image"`
+}
+
+// Stock reservation statuses
+const (
+	ReservationActive   = "active"
+	ReservationConsumed = "consumed"
+	ReservationReleased = "released"
+)
+
+// StockReservation holds stock for a cart while its owner completes checkout.
+// Active reservations past ExpiresAt no longer count and are released by the sweeper.
+type StockReservation struct {
+	ID        int64     `json:"id"`
+	CartID    int64     `json:"cart_id" gorm:"index"`
+	VariantID int64     `json:"variant_id" gorm:"index"`
+	Qty       int       `json:"qty"`
+	Status    string    `json:"status" gorm:"size:16;index"` // active, consumed, released
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // RefreshToken represents a refresh token stored in the database
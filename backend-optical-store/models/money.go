@@ -0,0 +1,112 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Currency is the ISO 4217 code of every stored amount
+const Currency = "BRL"
+
+// Money is an amount of Currency in minor units (centavos), stored as a BIGINT.
+// Sums and quantities are exact; amounts only become decimals at the edges, where
+// they are written with exactly two decimal places.
+//
+// Rounding rules: parsed amounts may not have more than two decimal places, and
+// fractional results (percentages, conversions) round half away from zero.
+type Money int64
+
+// ErrInvalidMoney is returned for amounts that are not plain decimals with at most two places
+var ErrInvalidMoney = errors.New("amount must be a decimal with at most two decimal places")
+
+// ParseMoney parses a decimal such as "299.99", "-5" or "0.5" exactly
+func ParseMoney(s string) (Money, error) {
+	units, ok := parseDecimal(s, 2)
+	if !ok {
+		return 0, ErrInvalidMoney
+	}
+	return Money(units), nil
+}
+
+// parseDecimal parses s into an integer of 10^-places units, rejecting more decimal places
+func parseDecimal(s string, places int) (int64, bool) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > places || !allDigits(whole) || !allDigits(frac) {
+		return 0, false
+	}
+	for len(frac) < places {
+		frac += "0"
+	}
+
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if negative {
+		units = -units
+	}
+	return units, true
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Mul returns the amount for qty units
+func (m Money) Mul(qty int) Money {
+	return m * Money(qty)
+}
+
+// MulRatio returns m * num / den for a positive den, rounded half away from zero
+func (m Money) MulRatio(num, den int64) Money {
+	product := int64(m) * num
+	if product < 0 {
+		return Money((product - den/2) / den)
+	}
+	return Money((product + den/2) / den)
+}
+
+// String formats the amount as a decimal with two places, e.g. "299.99"
+func (m Money) String() string {
+	units := int64(m)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	frac := strconv.FormatInt(units%100, 10)
+	if len(frac) < 2 {
+		frac = "0" + frac
+	}
+	return sign + strconv.FormatInt(units/100, 10) + "." + frac
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimal places
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a decimal string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	data = bytes.Trim(data, `"`)
+	v, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"299.99", 299_99, true},
+		{"299.9", 299_90, true},
+		{"299", 299_00, true},
+		{"0.5", 50, true},
+		{".5", 50, true},
+		{"5.", 5_00, true},
+		{"-5", -5_00, true},
+		{"+1.25", 1_25, true},
+		{" 10.00 ", 10_00, true},
+		{"0", 0, true},
+		{"1.234", 0, false},
+		{"", 0, false},
+		{".", 0, false},
+		{"-", 0, false},
+		{"1e3", 0, false},
+		{"1,50", 0, false},
+		{"abc", 0, false},
+		{"1.-5", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseMoney(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMulRatio(t *testing.T) {
+	tests := []struct {
+		m        Money
+		num, den int64
+		want     Money
+	}{
+		{100_00, 1, 3, 33_33},
+		{100_00, 2, 3, 66_67},
+		{1, 1, 2, 1},   // half rounds away from zero
+		{-1, 1, 2, -1}, // on both sides
+		{3, 1, 2, 2},
+		{-3, 1, 2, -2},
+		{10_00, 1800, 100_00, 1_80},
+		{0, 5, 7, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.m.MulRatio(tt.num, tt.den); got != tt.want {
+			t.Errorf("Money(%d).MulRatio(%d, %d) = %d, want %d", tt.m, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{50, "0.50"},
+		{299_99, "299.99"},
+		{-1_05, "-1.05"},
+		{-5, "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{`299.99`, 299_99, true},
+		{`"299.99"`, 299_99, true},
+		{`12`, 12_00, true},
+		{`0.001`, 0, false},
+		{`"x"`, 0, false},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err == nil) != tt.ok {
+			t.Errorf("Unmarshal(%s) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	out, err := json.Marshal(struct {
+		Price Money `json:"price"`
+	}{299_90})
+	if err != nil || string(out) != `{"price":299.90}` {
+		t.Errorf("Marshal = %s, %v; want {\"price\":299.90}", out, err)
+	}
+}
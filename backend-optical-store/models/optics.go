@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// PrescriptionEye holds the refraction values for one eye.
+// Nil fields were not prescribed.
+type PrescriptionEye struct {
+	Sphere    *float64 `json:"sphere"`               // diopters, -20.00 to +20.00 in 0.25 steps
+	Cylinder  *float64 `json:"cylinder"`             // diopters, -10.00 to +10.00 in 0.25 steps
+	Axis      *int     `json:"axis"`                 // degrees, 0 to 180, required with cylinder
+	Add       *float64 `json:"add"`                  // near addition, +0.75 to +4.00 in 0.25 steps
+	Prism     *float64 `json:"prism"`                // prism diopters, 0.25 to 10.00 in 0.25 steps
+	PrismBase string   `json:"prism_base,omitempty"` // up, down, in, out
+	PD        *float64 `json:"pd"`                   // monocular pupillary distance in mm, 20 to 40 in 0.5 steps
+}
+
+// PrescriptionValues is the structured part of a prescription sent by clients
+type PrescriptionValues struct {
+	Right PrescriptionEye `json:"right" gorm:"embedded;embeddedPrefix:od_"` // oculus dexter
+	Left  PrescriptionEye `json:"left" gorm:"embedded;embeddedPrefix:os_"`  // oculus sinister
+	PD    *float64        `json:"pd"`                                       // binocular pupillary distance in mm, 50 to 80 in 0.5 steps
+}
+
+var prismBases = map[string]bool{"up": true, "down": true, "in": true, "out": true}
+
+// HasValues reports whether any refraction value was recorded for the eye
+func (e PrescriptionEye) HasValues() bool {
+	return e.Sphere != nil || e.Cylinder != nil || e.Axis != nil || e.Add != nil || e.Prism != nil || e.PD != nil
+}
+
+// HasValues reports whether the prescription carries machine-readable values
+func (v PrescriptionValues) HasValues() bool {
+	return v.Right.HasValues() || v.Left.HasValues()
+}
+
+// HasNearAddition reports whether an ADD value was prescribed for either eye
+func (v PrescriptionValues) HasNearAddition() bool {
+	return (v.Right.Add != nil && *v.Right.Add > 0) || (v.Left.Add != nil && *v.Left.Add > 0)
+}
+
+// Validate checks the values against the ranges the lab accepts
+func (v PrescriptionValues) Validate() error {
+	if !v.HasValues() {
+		return fmt.Errorf("at least one eye must have values")
+	}
+	if err := v.Right.validate("right"); err != nil {
+		return err
+	}
+	if err := v.Left.validate("left"); err != nil {
+		return err
+	}
+
+	// PD is either binocular or given per eye, never both
+	monocular := v.Right.PD != nil || v.Left.PD != nil
+	if v.PD != nil && monocular {
+		return fmt.Errorf("give either pd or a per-eye pd, not both")
+	}
+	if monocular && (v.Right.PD == nil || v.Left.PD == nil) {
+		return fmt.Errorf("per-eye pd must be given for both eyes")
+	}
+	if v.PD != nil {
+		if err := checkStep("pd", *v.PD, 50, 80, 0.5); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e PrescriptionEye) validate(eye string) error {
+	if !e.HasValues() {
+		return nil
+	}
+
+	// Sphere is always written, as 0.00 (plano) when there is no spherical power
+	if e.Sphere == nil {
+		return fmt.Errorf("%s.sphere is required", eye)
+	}
+	if err := checkStep(eye+".sphere", *e.Sphere, -20, 20, 0.25); err != nil {
+		return err
+	}
+
+	hasCylinder := e.Cylinder != nil && *e.Cylinder != 0
+	if e.Cylinder != nil {
+		if err := checkStep(eye+".cylinder", *e.Cylinder, -10, 10, 0.25); err != nil {
+			return err
+		}
+	}
+	if hasCylinder && e.Axis == nil {
+		return fmt.Errorf("%s.axis is required with a cylinder", eye)
+	}
+	if !hasCylinder && e.Axis != nil {
+		return fmt.Errorf("%s.axis is only valid with a cylinder", eye)
+	}
+	if e.Axis != nil && (*e.Axis < 0 || *e.Axis > 180) {
+		return fmt.Errorf("%s.axis must be between 0 and 180", eye)
+	}
+
+	if e.Add != nil {
+		if err := checkStep(eye+".add", *e.Add, 0.75, 4, 0.25); err != nil {
+			return err
+		}
+	}
+
+	if (e.Prism == nil) != (e.PrismBase == "") {
+		return fmt.Errorf("%s.prism and %s.prism_base must be given together", eye, eye)
+	}
+	if e.Prism != nil {
+		if err := checkStep(eye+".prism", *e.Prism, 0.25, 10, 0.25); err != nil {
+			return err
+		}
+		if !prismBases[e.PrismBase] {
+			return fmt.Errorf("%s.prism_base must be up, down, in or out", eye)
+		}
+	}
+
+	if e.PD != nil {
+		if err := checkStep(eye+".pd", *e.PD, 20, 40, 0.5); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStep verifies value lies in [min, max] on a multiple of step
+func checkStep(field string, value, min, max, step float64) error {
+	if value < min || value > max {
+		return fmt.Errorf("%s must be between %.2f and %.2f", field, min, max)
+	}
+	steps := value / step
+	if math.Abs(steps-math.Round(steps)) > 1e-9 {
+		return fmt.Errorf("%s must be in %.2f steps", field, step)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStep(t *testing.T) {
+	tests := []struct {
+		value, min, max, step float64
+		ok                    bool
+	}{
+		{-20, -20, 20, 0.25, true},
+		{20, -20, 20, 0.25, true},
+		{-1.75, -20, 20, 0.25, true},
+		{0, -20, 20, 0.25, true},
+		{2.1, -20, 20, 0.25, false},
+		{0.3, -20, 20, 0.25, false},
+		{20.25, -20, 20, 0.25, false},
+		{-20.25, -20, 20, 0.25, false},
+		{31.5, 20, 40, 0.5, true},
+		{31.25, 20, 40, 0.5, false},
+		{0.1 + 0.2, 0, 1, 0.1, true}, // float noise stays on the step
+	}
+	for _, tt := range tests {
+		err := checkStep("field", tt.value, tt.min, tt.max, tt.step)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkStep(%v, %v..%v by %v) = %v, want ok %v", tt.value, tt.min, tt.max, tt.step, err, tt.ok)
+		}
+	}
+}
+
+func TestPrescriptionValuesValidate(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	i := func(v int) *int { return &v }
+
+	tests := []struct {
+		name   string
+		values PrescriptionValues
+		want   string // part of the error, "" when valid
+	}{
+		{"empty", PrescriptionValues{}, "at least one eye"},
+		{"sphere only", PrescriptionValues{Right: PrescriptionEye{Sphere: f(-2.5)}}, ""},
+		{"sphere off step", PrescriptionValues{Right: PrescriptionEye{Sphere: f(-2.3)}}, "right.sphere must be in 0.25 steps"},
+		{"sphere out of range", PrescriptionValues{Left: PrescriptionEye{Sphere: f(21)}}, "left.sphere must be between"},
+		{"sphere missing", PrescriptionValues{Right: PrescriptionEye{Add: f(1.5)}}, "right.sphere is required"},
+		{"cylinder with axis", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Cylinder: f(-0.75), Axis: i(90)}}, ""},
+		{"cylinder off step", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Cylinder: f(-0.8), Axis: i(90)}}, "right.cylinder must be in 0.25 steps"},
+		{"cylinder without axis", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Cylinder: f(-0.75)}}, "right.axis is required"},
+		{"zero cylinder without axis", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Cylinder: f(0)}}, ""},
+		{"axis without cylinder", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Axis: i(90)}}, "right.axis is only valid"},
+		{"axis 0", PrescriptionValues{Left: PrescriptionEye{Sphere: f(0), Cylinder: f(1), Axis: i(0)}}, ""},
+		{"axis 180", PrescriptionValues{Left: PrescriptionEye{Sphere: f(0), Cylinder: f(1), Axis: i(180)}}, ""},
+		{"axis 181", PrescriptionValues{Left: PrescriptionEye{Sphere: f(0), Cylinder: f(1), Axis: i(181)}}, "left.axis must be between 0 and 180"},
+		{"negative axis", PrescriptionValues{Left: PrescriptionEye{Sphere: f(0), Cylinder: f(1), Axis: i(-1)}}, "left.axis must be between 0 and 180"},
+		{"add below range", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Add: f(0.5)}}, "right.add must be between"},
+		{"prism without base", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Prism: f(1)}}, "must be given together"},
+		{"prism bad base", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), Prism: f(1), PrismBase: "left"}}, "right.prism_base must be"},
+		{"binocular pd", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0)}, PD: f(63.5)}, ""},
+		{"binocular pd bounds", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0)}, PD: f(50)}, ""},
+		{"binocular pd too small", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0)}, PD: f(49.5)}, "pd must be between 50.00 and 80.00"},
+		{"binocular pd too large", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0)}, PD: f(80.5)}, "pd must be between 50.00 and 80.00"},
+		{"binocular pd off step", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0)}, PD: f(63.2)}, "pd must be in 0.50 steps"},
+		{"monocular pd", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), PD: f(31.5)}, Left: PrescriptionEye{Sphere: f(0), PD: f(32)}}, ""},
+		{"monocular pd one eye", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), PD: f(31.5)}}, "per-eye pd must be given for both eyes"},
+		{"monocular pd too large", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), PD: f(40.5)}, Left: PrescriptionEye{Sphere: f(0), PD: f(32)}}, "right.pd must be between 20.00 and 40.00"},
+		{"both pd kinds", PrescriptionValues{Right: PrescriptionEye{Sphere: f(0), PD: f(31.5)}, Left: PrescriptionEye{Sphere: f(0), PD: f(32)}, PD: f(63.5)}, "either pd or a per-eye pd"},
+	}
+	for _, tt := range tests {
+		err := tt.values.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: Validate = %v, want nil", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: Validate = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Order lifecycle statuses
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderInLab     = "in_lab"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
+// ErrInvalidTransition is returned when an order can't move to the requested status
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// orderTransitions lists the statuses each status may move to.
+// Orders without lens work can skip the lab and ship straight after payment.
+var orderTransitions = map[string][]string{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderInLab, OrderShipped, OrderCancelled},
+	OrderInLab:     {OrderShipped, OrderCancelled},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderRefunded},
+	OrderCancelled: {OrderRefunded},
+	OrderRefunded:  {},
+}
+
+// IsOrderStatus reports whether status is a known order status
+func IsOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionTo moves the order to status and stamps the matching timestamp
+func (o *Order) TransitionTo(status string, at time.Time) error {
+	if !CanTransition(o.Status, status) {
+		return ErrInvalidTransition
+	}
+	// Only orders that were actually paid can be refunded after cancellation
+	if o.Status == OrderCancelled && status == OrderRefunded && o.PaidAt == nil {
+		return ErrInvalidTransition
+	}
+
+	switch status {
+	case OrderPaid:
+		o.PaidAt = &at
+	case OrderInLab:
+		o.InLabAt = &at
+	case OrderShipped:
+		o.ShippedAt = &at
+	case OrderDelivered:
+		o.DeliveredAt = &at
+	case OrderCancelled:
+		o.CancelledAt = &at
+	case OrderRefunded:
+		o.RefundedAt = &at
+	}
+	o.Status = status
+	return nil
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{OrderPending, OrderPaid, OrderInLab, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded}
+	allowed := map[[2]string]bool{
+		{OrderPending, OrderPaid}:       true,
+		{OrderPending, OrderCancelled}:  true,
+		{OrderPaid, OrderInLab}:         true,
+		{OrderPaid, OrderShipped}:       true,
+		{OrderPaid, OrderCancelled}:     true,
+		{OrderInLab, OrderShipped}:      true,
+		{OrderInLab, OrderCancelled}:    true,
+		{OrderShipped, OrderDelivered}:  true,
+		{OrderDelivered, OrderRefunded}: true,
+		{OrderCancelled, OrderRefunded}: true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if CanTransition("unknown", OrderPaid) || CanTransition(OrderPending, "unknown") {
+		t.Error("CanTransition allowed an unknown status")
+	}
+}
+
+func TestOrderTransitionTo(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	paid := at.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		order   Order
+		to      string
+		wantErr error
+		stamp   func(*Order) *time.Time
+	}{
+		{"pay", Order{Status: OrderPending}, OrderPaid, nil, func(o *Order) *time.Time { return o.PaidAt }},
+		{"send to lab", Order{Status: OrderPaid}, OrderInLab, nil, func(o *Order) *time.Time { return o.InLabAt }},
+		{"ship without lab", Order{Status: OrderPaid}, OrderShipped, nil, func(o *Order) *time.Time { return o.ShippedAt }},
+		{"deliver", Order{Status: OrderShipped}, OrderDelivered, nil, func(o *Order) *time.Time { return o.DeliveredAt }},
+		{"cancel", Order{Status: OrderInLab}, OrderCancelled, nil, func(o *Order) *time.Time { return o.CancelledAt }},
+		{"refund delivered", Order{Status: OrderDelivered}, OrderRefunded, nil, func(o *Order) *time.Time { return o.RefundedAt }},
+		{"refund paid cancellation", Order{Status: OrderCancelled, PaidAt: &paid}, OrderRefunded, nil, func(o *Order) *time.Time { return o.RefundedAt }},
+		{"refund unpaid cancellation", Order{Status: OrderCancelled}, OrderRefunded, ErrInvalidTransition, nil},
+		{"cancel shipped", Order{Status: OrderShipped}, OrderCancelled, ErrInvalidTransition, nil},
+		{"skip payment", Order{Status: OrderPending}, OrderShipped, ErrInvalidTransition, nil},
+		{"leave refunded", Order{Status: OrderRefunded}, OrderPending, ErrInvalidTransition, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			from := order.Status
+			err := order.TransitionTo(tt.to, at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransitionTo(%s) error = %v, want %v", tt.to, err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if order.Status != from {
+					t.Errorf("status changed to %s on a rejected transition", order.Status)
+				}
+				return
+			}
+			if order.Status != tt.to {
+				t.Errorf("status = %s, want %s", order.Status, tt.to)
+			}
+			if stamp := tt.stamp(&order); stamp == nil || !stamp.Equal(at) {
+				t.Errorf("timestamp for %s = %v, want %v", tt.to, stamp, at)
+			}
+		})
+	}
+}
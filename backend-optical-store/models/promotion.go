@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Promotion kinds
+const (
+	PromotionPercentOff   = "percent_off"   // Percent off the matching prices
+	PromotionFixedOff     = "fixed_off"     // Amount off each matching unit, up to its price
+	PromotionBuyOneGetOne = "bogo"          // every second matching unit free, the cheaper of each pair
+	PromotionFreeShipping = "free_shipping" // the shipping fee is waived
+)
+
+// Promotion targets: which part of each cart line a promotion discounts
+const (
+	PromotionTargetAll      = "all"      // the whole line
+	PromotionTargetProducts = "products" // the frame or product, optionally by category
+	PromotionTargetLenses   = "lenses"   // the lenses fitted to a frame
+)
+
+// Reasons a promotion can't be used right now
+var (
+	ErrPromotionInactive   = errors.New("promotion is not active")
+	ErrPromotionNotStarted = errors.New("promotion has not started yet")
+	ErrPromotionExpired    = errors.New("promotion has expired")
+	ErrPromotionUsedUp     = errors.New("promotion usage limit reached")
+	ErrPromotionUserLimit  = errors.New("promotion already used the maximum number of times")
+)
+
+// Promotion is a discount applied to carts automatically or, when it has a Code,
+// once the customer enters the coupon code
+type Promotion struct {
+	ID   int64   `json:"id"`
+	Name string  `json:"name" gorm:"size:128"`                      // shown on the discount line
+	Code *string `json:"code,omitempty" gorm:"size:64;uniqueIndex"` // upper case; nil for automatic promotions
+	Kind string  `json:"kind" gorm:"size:16"`
+	// Target and CategoryID select the discounted prices; free shipping ignores them
+	Target     string  `json:"target" gorm:"size:16"`
+	CategoryID *int64  `json:"category_id,omitempty"` // products target only, subcategories included
+	Percent    Percent `json:"percent"`               // percent_off
+	Amount     Money   `json:"amount"`                // fixed_off, per unit
+	// MinSubtotal is the cart subtotal from which the promotion applies, e.g. the free shipping threshold
+	MinSubtotal  Money      `json:"min_subtotal"`
+	StartsAt     *time.Time `json:"starts_at,omitempty"`
+	EndsAt       *time.Time `json:"ends_at,omitempty"`
+	UsageLimit   int        `json:"usage_limit"`    // orders across all customers, 0 for unlimited
+	PerUserLimit int        `json:"per_user_limit"` // orders per customer, 0 for unlimited
+	UsedCount    int        `json:"used_count"`     // orders placed with the promotion and not cancelled
+	Active       bool       `json:"active"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Available reports why the promotion can't be used at t by a customer who has
+// already used it usedByUser times, or nil when it can
+func (p *Promotion) Available(t time.Time, usedByUser int) error {
+	switch {
+	case !p.Active:
+		return ErrPromotionInactive
+	case p.StartsAt != nil && t.Before(*p.StartsAt):
+		return ErrPromotionNotStarted
+	case p.EndsAt != nil && !t.Before(*p.EndsAt):
+		return ErrPromotionExpired
+	case p.UsageLimit > 0 && p.UsedCount >= p.UsageLimit:
+		return ErrPromotionUsedUp
+	case p.PerUserLimit > 0 && usedByUser >= p.PerUserLimit:
+		return ErrPromotionUserLimit
+	}
+	return nil
+}
+
+// PromotionRedemption records a promotion used by an order, for the usage limits
+type PromotionRedemption struct {
+	ID          int64     `json:"id"`
+	PromotionID int64     `json:"promotion_id" gorm:"index"`
+	UserID      int64     `json:"user_id" gorm:"index"`
+	OrderID     int64     `json:"order_id" gorm:"index"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DiscountLine is the discount a promotion gives on a cart or order
+type DiscountLine struct {
+	PromotionID int64  `json:"promotion_id"`
+	Name        string `json:"name" gorm:"size:128"`
+	Code        string `json:"code,omitempty" gorm:"size:64"`
+	Kind        string `json:"kind" gorm:"size:16"`
+	Amount      Money  `json:"amount"`
+}
+
+// OrderDiscount is a discount line recorded on an order when it is placed
+type OrderDiscount struct {
+	ID           int64 `json:"-"`
+	OrderID      int64 `json:"-" gorm:"index"`
+	DiscountLine `gorm:"embedded"`
+}
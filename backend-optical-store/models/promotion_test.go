@@ -0,0 +1,37 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromotionAvailable(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		promotion  Promotion
+		usedByUser int
+		want       error
+	}{
+		{"open", Promotion{Active: true}, 0, nil},
+		{"inactive", Promotion{}, 0, ErrPromotionInactive},
+		{"within window", Promotion{Active: true, StartsAt: &before, EndsAt: &after}, 0, nil},
+		{"not started", Promotion{Active: true, StartsAt: &after}, 0, ErrPromotionNotStarted},
+		{"starts now", Promotion{Active: true, StartsAt: &now}, 0, nil},
+		{"expired", Promotion{Active: true, EndsAt: &before}, 0, ErrPromotionExpired},
+		{"ends now", Promotion{Active: true, EndsAt: &now}, 0, ErrPromotionExpired},
+		{"below usage limit", Promotion{Active: true, UsageLimit: 10, UsedCount: 9}, 0, nil},
+		{"used up", Promotion{Active: true, UsageLimit: 10, UsedCount: 10}, 0, ErrPromotionUsedUp},
+		{"unlimited", Promotion{Active: true, UsedCount: 1000}, 5, nil},
+		{"below user limit", Promotion{Active: true, PerUserLimit: 2}, 1, nil},
+		{"user limit reached", Promotion{Active: true, PerUserLimit: 1}, 1, ErrPromotionUserLimit},
+	}
+	for _, tt := range tests {
+		if got := tt.promotion.Available(now, tt.usedByUser); !errors.Is(got, tt.want) {
+			t.Errorf("%s: Available = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
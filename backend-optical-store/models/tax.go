@@ -0,0 +1,101 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// Tax modes
+const (
+	TaxExclusive = "exclusive" // added on top of the price
+	TaxInclusive = "inclusive" // already contained in the price
+)
+
+// Tax rule targets
+const (
+	TaxTargetProducts = "products" // the frame or product part of a line, by category
+	TaxTargetLenses   = "lenses"   // the lens part of a line, often taxed as a medical device
+)
+
+// Percent is a tax rate in hundredths of a percent: 1800 is 18%
+type Percent int64
+
+// ErrInvalidPercent is returned for rates that are not decimals from 0 to 100 with at most two places
+var ErrInvalidPercent = errors.New("rate must be a percentage from 0 to 100 with at most two decimal places")
+
+// ParsePercent parses a percentage such as "18" or "7.25" exactly
+func ParsePercent(s string) (Percent, error) {
+	units, ok := parseDecimal(s, 2)
+	if !ok || units < 0 || units > 100_00 {
+		return 0, ErrInvalidPercent
+	}
+	return Percent(units), nil
+}
+
+// Of is the tax added on top of an exclusive amount
+func (p Percent) Of(m Money) Money {
+	return m.MulRatio(int64(p), 100_00)
+}
+
+// ContainedIn is the tax included in an inclusive amount
+func (p Percent) ContainedIn(m Money) Money {
+	return m.MulRatio(int64(p), 100_00+int64(p))
+}
+
+// String formats the rate with two decimal places, e.g. "18.00"
+func (p Percent) String() string {
+	return Money(p).String()
+}
+
+// MarshalJSON writes the rate as a JSON number with two decimal places
+func (p Percent) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a decimal string
+func (p *Percent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	v, err := ParsePercent(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+// TaxRule taxes sales shipped to a country, or one of its states, at a rate.
+// Rules with different names stack (e.g. a state and a federal tax); among rules
+// of the same name the most specific one applies to each line.
+type TaxRule struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name" gorm:"size:64"`         // shown on the cart and order, e.g. "ICMS"
+	Country string `json:"country" gorm:"size:2;index"` // ISO 3166-1 alpha-2, matched against Address.Country
+	State   string `json:"state" gorm:"size:64"`        // matched against Address.State; empty for the whole country
+	Target  string `json:"target" gorm:"size:16"`       // products or lenses
+	// CategoryID limits a products rule to a category and its subcategories; nil for every category
+	CategoryID *int64    `json:"category_id,omitempty"`
+	Rate       Percent   `json:"rate"`
+	Mode       string    `json:"mode" gorm:"size:16"` // exclusive or inclusive
+	Active     bool      `json:"active"`              // no column default, so creating an inactive rule sticks
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// TaxLine is the tax charged at one rate on a cart or order
+type TaxLine struct {
+	Name    string  `json:"name" gorm:"size:64"`
+	Rate    Percent `json:"rate"`
+	Mode    string  `json:"mode" gorm:"size:16"`
+	Taxable Money   `json:"taxable_amount"` // the amount the rate applies to
+	Amount  Money   `json:"amount"`
+}
+
+// OrderTax is a tax line recorded on an order when it is placed
+type OrderTax struct {
+	ID      int64 `json:"-"`
+	OrderID int64 `json:"-" gorm:"index"`
+	TaxLine `gorm:"embedded"`
+}
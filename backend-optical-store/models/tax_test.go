@@ -0,0 +1,52 @@
+package models
+
+import "testing"
+
+func TestParsePercent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Percent
+		ok   bool
+	}{
+		{"18", 18_00, true},
+		{"7.25", 7_25, true},
+		{"0", 0, true},
+		{"100", 100_00, true},
+		{"100.01", 0, false},
+		{"-1", 0, false},
+		{"7.125", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParsePercent(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParsePercent(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePercent(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPercentOfAndContainedIn(t *testing.T) {
+	tests := []struct {
+		rate          Percent
+		amount        Money
+		of, contained Money
+	}{
+		{18_00, 100_00, 18_00, 15_25}, // 18 on top; 100 * 18/118 = 15.254
+		{7_25, 299_90, 21_74, 20_27},  // 21.742; 20.2727
+		{10_00, 5, 1, 0},              // 0.5 rounds away from zero; 0.4545 rounds down
+		{100_00, 10_00, 10_00, 5_00},  // everything on top; half contained
+		{0, 123_45, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.rate.Of(tt.amount); got != tt.of {
+			t.Errorf("Percent(%s).Of(%s) = %s, want %s", tt.rate, tt.amount, got, tt.of)
+		}
+		if got := tt.rate.ContainedIn(tt.amount); got != tt.contained {
+			t.Errorf("Percent(%s).ContainedIn(%s) = %s, want %s", tt.rate, tt.amount, got, tt.contained)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package notify delivers operational alerts to staff.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Notifier sends an alert with a subject and a plain-text body
+type Notifier interface {
+	Notify(ctx context.Context, subject, body string) error
+}
+
+// LogNotifier writes alerts to the application log
+type LogNotifier struct{}
+
+// Notify logs the alert
+func (LogNotifier) Notify(ctx context.Context, subject, body string) error {
+	log.Printf("ALERT: %s\n%s", subject, body)
+	return nil
+}
+
+// SMTPNotifier mails alerts through an SMTP relay, typically one on localhost
+type SMTPNotifier struct {
+	Addr string // host:port
+	From string
+	To   []string
+	Auth smtp.Auth // nil for an unauthenticated local relay
+}
+
+// Notify sends the alert as a plain-text email
+func (n SMTPNotifier) Notify(ctx context.Context, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		n.From, strings.Join(n.To, ", "), subject, strings.ReplaceAll(body, "\n", "\r\n"))
+	if err := smtp.SendMail(n.Addr, n.Auth, n.From, n.To, []byte(msg)); err != nil {
+		return fmt.Errorf("sending alert mail: %w", err)
+	}
+	return nil
+}
+
+// FromEnv returns an SMTPNotifier when ALERT_SMTP_ADDR and ALERT_EMAIL_TO are set,
+// and a LogNotifier otherwise
+func FromEnv() Notifier {
+	addr := os.Getenv("ALERT_SMTP_ADDR")
+	to := os.Getenv("ALERT_EMAIL_TO")
+	if addr == "" || to == "" {
+		return LogNotifier{}
+	}
+
+	from := os.Getenv("ALERT_EMAIL_FROM")
+	if from == "" {
+		from = "alerts@localhost"
+	}
+
+	var recipients []string
+	for _, r := range strings.Split(to, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("ALERT_SMTP_USER"); user != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv("ALERT_SMTP_PASSWORD"), host)
+	}
+
+	return SMTPNotifier{Addr: addr, From: from, To: recipients, Auth: auth}
+}
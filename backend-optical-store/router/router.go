@@ -3,6 +3,7 @@ package router
 import (
 	"backend-optical-store/handlers"
 	"backend-optical-store/middleware"
+	"backend-optical-store/models"
 
 	"net/http"
 
@@ -20,6 +21,11 @@ func New(db *gorm.DB) chi.Router {
 	r.Post("/api/refresh-token", handlers.RefreshToken(db))
 	r.Get("/api/products/{id}", handlers.GetProduct(db))
 	r.Get("/api/products", handlers.GetProducts(db))
+	r.Get("/api/products/{id}/variants", handlers.GetVariants(db))
+	r.Get("/api/lens-options", handlers.GetLensOptions(db))
+	r.Get("/api/exchange-rates", handlers.GetExchangeRates(db))
+	r.Get("/api/categories", handlers.GetCategories(db))
+	r.Get("/api/categories/tree", handlers.GetCategoryTree(db))
 
 	fileServer := http.FileServer(http.Dir("./uploads"))
 	r.Handle("/api/uploads/*", http.StripPrefix("/api/uploads/", fileServer)) // Protected routes
@@ -30,10 +36,30 @@ func New(db *gorm.DB) chi.Router {
 			r.Put("/profile", handlers.UpdateProfile(db))
 			r.Delete("/profile", handlers.DeleteProfile(db))
 
+			// Address book routes
+			r.Route("/profile/addresses", func(r chi.Router) {
+				r.Get("/", handlers.GetAddresses(db))
+				r.Post("/", handlers.CreateAddress(db))
+				r.Put("/{id}", handlers.UpdateAddress(db))
+				r.Delete("/{id}", handlers.DeleteAddress(db))
+			})
+
 			// Products management routes
-			r.Post("/products", handlers.CreateProduct(db))
-			r.Put("/products/{id}", handlers.UpdateProduct(db))
-			r.Delete("/products/{id}", handlers.DeleteProduct(db))
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireRole(models.RoleAdmin))
+				r.Post("/products", handlers.CreateProduct(db))
+				r.Post("/products/import", handlers.ImportProducts(db))
+				r.Put("/products/{id}", handlers.UpdateProduct(db))
+				r.Delete("/products/{id}", handlers.DeleteProduct(db))
+				r.Post("/products/{id}/variants", handlers.CreateVariant(db))
+				r.Put("/products/{id}/variants/{variantID}", handlers.UpdateVariant(db))
+				r.Delete("/products/{id}/variants/{variantID}", handlers.DeleteVariant(db))
+
+				// Category management routes
+				r.Post("/categories", handlers.CreateCategory(db))
+				r.Put("/categories/{id}", handlers.UpdateCategory(db))
+				r.Delete("/categories/{id}", handlers.DeleteCategory(db))
+			})
 
 			// Cart routes
 			r.Route("/cart", func(r chi.Router) {
@@ -42,6 +68,55 @@ func New(db *gorm.DB) chi.Router {
 				r.Put("/items/{id}", handlers.UpdateCartItem(db))
 				r.Delete("/items/{id}", handlers.RemoveFromCart(db))
 				r.Delete("/clear", handlers.ClearCart(db))
+				r.Post("/coupon", handlers.ApplyCoupon(db))
+				r.Delete("/coupon", handlers.RemoveCoupon(db))
+			})
+
+			// Order routes
+			r.Post("/checkout/begin", handlers.BeginCheckout(db))
+			r.Post("/checkout", handlers.Checkout(db))
+			r.Get("/orders", handlers.GetOrders(db))
+			r.Get("/orders/{id}", handlers.GetOrder(db))
+
+			// Prescription routes
+			r.Post("/prescriptions", handlers.UploadPrescription(db))
+			r.Get("/prescriptions", handlers.GetPrescriptions(db))
+			r.Get("/prescriptions/{id}/file", handlers.GetPrescriptionFile(db))
+			r.Put("/prescriptions/{id}/values", handlers.SetPrescriptionValues(db))
+
+			// Back-office routes
+			r.Route("/admin", func(r chi.Router) {
+				// Prescription review is shared with opticians
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequireRole(models.RoleAdmin, models.RoleOptician))
+					r.Get("/prescriptions", handlers.GetPrescriptionQueue(db))
+					r.Put("/prescriptions/{id}/values", handlers.AdminSetPrescriptionValues(db))
+					r.Post("/prescriptions/{id}/verify", handlers.VerifyPrescription(db))
+					r.Post("/prescriptions/{id}/reject", handlers.RejectPrescription(db))
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequireRole(models.RoleAdmin))
+					r.Put("/users/{id}/role", handlers.UpdateUserRole(db))
+					r.Get("/orders", handlers.AdminGetOrders(db))
+					r.Get("/products/export", handlers.ExportProducts(db))
+					r.Post("/orders/{id}/transition", handlers.TransitionOrder(db))
+					r.Get("/inventory/movements", handlers.GetInventoryMovements(db))
+					r.Post("/inventory/movements", handlers.PostInventoryMovement(db))
+					r.Get("/inventory/reconcile", handlers.ReconcileInventory(db))
+					r.Post("/inventory/reconcile", handlers.ReconcileInventory(db))
+					r.Get("/inventory/low-stock", handlers.GetLowStockReport(db))
+					r.Put("/exchange-rates/{currency}", handlers.PutExchangeRate(db))
+					r.Delete("/exchange-rates/{currency}", handlers.DeleteExchangeRate(db))
+					r.Get("/tax-rules", handlers.GetTaxRules(db))
+					r.Post("/tax-rules", handlers.CreateTaxRule(db))
+					r.Put("/tax-rules/{id}", handlers.UpdateTaxRule(db))
+					r.Delete("/tax-rules/{id}", handlers.DeleteTaxRule(db))
+					r.Get("/promotions", handlers.GetPromotions(db))
+					r.Post("/promotions", handlers.CreatePromotion(db))
+					r.Put("/promotions/{id}", handlers.UpdatePromotion(db))
+					r.Delete("/promotions/{id}", handlers.DeletePromotion(db))
+				})
 			})
 		})
 	})
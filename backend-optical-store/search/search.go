@@ -0,0 +1,162 @@
+// Package search implements catalog search on top of MySQL FULLTEXT indexes.
+//
+// Every product carries a search document (name, description, category name and
+// the SKU, color and size of each variant) indexed alongside the product name.
+// Queries are split into words, misspelled words are corrected against the
+// catalog vocabulary, and results are ranked with name matches counting double.
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	"backend-optical-store/models"
+)
+
+// minTokenSize mirrors InnoDB's innodb_ft_min_token_size; shorter words are not
+// in the FULLTEXT index and are matched with LIKE instead
+const minTokenSize = 3
+
+// Query is a parsed search string
+type Query struct {
+	Terms     []string // words matched through the FULLTEXT index, after correction
+	Short     []string // words too short for the index
+	Corrected bool     // true when at least one word was spelled differently
+}
+
+// Parse splits raw into words and corrects words the catalog does not contain
+func Parse(db *gorm.DB, raw string) Query {
+	var q Query
+	vocab := vocabularyFor(db)
+	for _, word := range Tokenize(raw) {
+		if len([]rune(word)) < minTokenSize {
+			q.Short = append(q.Short, word)
+			continue
+		}
+		if fixed, ok := vocab.correct(word); ok {
+			if fixed != word {
+				q.Corrected = true
+			}
+			q.Terms = append(q.Terms, strings.Fields(fixed)...)
+			continue
+		}
+		q.Terms = append(q.Terms, word)
+	}
+	return q
+}
+
+// Empty reports whether the query has no words at all
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Short) == 0
+}
+
+// String returns the query as it is actually searched
+func (q Query) String() string {
+	return strings.Join(append(append([]string{}, q.Terms...), q.Short...), " ")
+}
+
+// Filter restricts a products query to products containing every word
+func (q Query) Filter(tx *gorm.DB) *gorm.DB {
+	if len(q.Terms) > 0 {
+		// Every word is required; the trailing * also matches longer words
+		boolean := make([]string, len(q.Terms))
+		for i, term := range q.Terms {
+			boolean[i] = "+" + term + "*"
+		}
+		tx = tx.Where("MATCH(products.search_document) AGAINST (? IN BOOLEAN MODE)", strings.Join(boolean, " "))
+	}
+	for _, word := range q.Short {
+		tx = tx.Where("products.search_document LIKE ?", "%"+word+"%")
+	}
+	return tx
+}
+
+// OrderByRelevance sorts the best matches first; name matches weigh double.
+// The score is selected as "relevance" so later Order calls can add tie-breakers.
+func (q Query) OrderByRelevance(tx *gorm.DB) *gorm.DB {
+	if len(q.Terms) == 0 {
+		return tx
+	}
+	text := strings.Join(q.Terms, " ")
+	return tx.Select("products.*, MATCH(products.name) AGAINST (?) * 2 + MATCH(products.search_document) AGAINST (?) AS relevance", text, text).
+		Order("relevance DESC")
+}
+
+// Tokenize lower-cases s and splits it into words of letters and digits
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Document builds the text indexed for a product
+func Document(product models.Product, categoryName string) string {
+	parts := []string{product.Name, product.Description, categoryName}
+	for _, v := range product.Variants {
+		parts = append(parts, v.SKU, v.Color, v.Size)
+	}
+
+	var b strings.Builder
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			b.WriteString(part)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// Refresh rebuilds the search documents of the given products. Call it after
+// changing a product, its variants or its category.
+func Refresh(tx *gorm.DB, productIDs ...int64) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	return refresh(tx, "id IN ?", productIDs)
+}
+
+// RefreshCategory rebuilds the documents of every product in a category
+func RefreshCategory(tx *gorm.DB, categoryID int64) error {
+	return refresh(tx, "category_id = ?", categoryID)
+}
+
+// RefreshMissing builds documents for products that have none yet
+func RefreshMissing(db *gorm.DB) (int, error) {
+	var ids []int64
+	if err := db.Model(&models.Product{}).
+		Where("search_document IS NULL OR search_document = ''").
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), Refresh(db, ids...)
+}
+
+func refresh(tx *gorm.DB, where string, args ...interface{}) error {
+	var products []models.Product
+	if err := tx.Where(where, args...).Preload("Variants").Find(&products).Error; err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return nil
+	}
+
+	var categories []models.Category
+	if err := tx.Select("id", "name").Find(&categories).Error; err != nil {
+		return err
+	}
+	names := make(map[int64]string, len(categories))
+	for _, c := range categories {
+		names[c.ID] = c.Name
+	}
+
+	for _, p := range products {
+		if err := tx.Model(&models.Product{}).Where("id = ?", p.ID).
+			Update("search_document", Document(p, names[p.CategoryID])).Error; err != nil {
+			return err
+		}
+	}
+	invalidateVocabulary()
+	return nil
+}
@@ -0,0 +1,176 @@
+package search
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend-optical-store/models"
+)
+
+// vocabularyTTL bounds how stale the vocabulary gets when products change
+// without going through Refresh
+const vocabularyTTL = 5 * time.Minute
+
+// vocabulary maps every word of the catalog to the terms it searches for and
+// counts how often it occurs, to prefer common words when correcting typos
+type vocabulary struct {
+	terms map[string]string
+	freq  map[string]int
+}
+
+var (
+	vocabMu    sync.Mutex
+	vocab      *vocabulary
+	vocabBuilt time.Time
+)
+
+// vocabularyFor returns the cached vocabulary, rebuilding it when stale
+func vocabularyFor(db *gorm.DB) *vocabulary {
+	vocabMu.Lock()
+	defer vocabMu.Unlock()
+
+	if vocab != nil && time.Since(vocabBuilt) < vocabularyTTL {
+		return vocab
+	}
+
+	var products []models.Product
+	if err := db.Select("name", "search_document").Find(&products).Error; err != nil {
+		log.Printf("Error loading search vocabulary: %v", err)
+		if vocab == nil {
+			return &vocabulary{}
+		}
+		return vocab
+	}
+
+	v := &vocabulary{terms: map[string]string{}, freq: map[string]int{}}
+	for _, p := range products {
+		for _, word := range Tokenize(p.SearchDocument) {
+			v.add(word, word)
+		}
+
+		// Brand names are often typed as one word ("rayban" for "Ray-Ban"),
+		// so joined pairs of name words search for both words
+		words := Tokenize(p.Name)
+		for i := 0; i+1 < len(words); i++ {
+			v.add(words[i]+words[i+1], words[i]+" "+words[i+1])
+		}
+	}
+
+	vocab = v
+	vocabBuilt = time.Now()
+	return vocab
+}
+
+// invalidateVocabulary makes the next search rebuild the vocabulary
+func invalidateVocabulary() {
+	vocabMu.Lock()
+	vocab = nil
+	vocabMu.Unlock()
+}
+
+func (v *vocabulary) add(word, terms string) {
+	if len([]rune(word)) < minTokenSize {
+		return
+	}
+	if _, ok := v.terms[word]; !ok {
+		v.terms[word] = terms
+	}
+	v.freq[word]++
+}
+
+// correct returns the terms to search for word, either the word's own entry or
+// that of the closest catalog word within the allowed number of typos
+func (v *vocabulary) correct(word string) (string, bool) {
+	if terms, ok := v.terms[word]; ok {
+		return terms, true
+	}
+
+	maxEdits := allowedEdits(word)
+	if maxEdits == 0 {
+		return "", false
+	}
+
+	best, bestDist := "", maxEdits+1
+	for candidate := range v.terms {
+		// Words that are also a prefix of a catalog word are left to the index
+		if strings.HasPrefix(candidate, word) {
+			return "", false
+		}
+		d := editDistance(word, candidate, maxEdits)
+		if d < bestDist || (d == bestDist && d <= maxEdits && v.better(candidate, best)) {
+			best, bestDist = candidate, d
+		}
+	}
+	if bestDist > maxEdits {
+		return "", false
+	}
+	return v.terms[best], true
+}
+
+// better breaks ties between equally close candidates: most frequent, then alphabetical
+func (v *vocabulary) better(a, b string) bool {
+	if v.freq[a] != v.freq[b] {
+		return v.freq[a] > v.freq[b]
+	}
+	return a < b
+}
+
+// allowedEdits is how many typos a word of this length may contain; numbers
+// (model codes, sizes) must match exactly
+func allowedEdits(word string) int {
+	for _, r := range word {
+		if r >= '0' && r <= '9' {
+			return 0
+		}
+	}
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment) distance
+// between a and b, giving up with max+1 once it is known to exceed max
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			// Swapped neighbours count as one typo
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,73 @@
+package search
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"aviator", "aviator", 2, 0},
+		{"aviatro", "aviator", 2, 1},  // swapped neighbours are one edit
+		{"aviatr", "aviator", 2, 1},   // deletion
+		{"aviattor", "aviator", 2, 1}, // insertion
+		{"avaator", "aviator", 2, 1},  // substitution
+		{"avtaior", "aviator", 2, 2},
+		{"ca", "abc", 3, 3},        // optimal string alignment, not full Damerau-Levenshtein
+		{"óculos", "oculos", 2, 1}, // runes, not bytes
+		{"round", "aviator", 2, 3}, // gives up with max+1
+		{"ab", "abcdef", 2, 3},     // length difference alone exceeds max
+		{"", "abc", 3, 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedEdits(t *testing.T) {
+	tests := map[string]int{
+		"ray":           0,
+		"oval":          1,
+		"polarized":     2,
+		"rb3025":        0, // model codes must match exactly
+		"óculos":        1,
+		"tortoiseshell": 2,
+	}
+	for word, want := range tests {
+		if got := allowedEdits(word); got != want {
+			t.Errorf("allowedEdits(%q) = %d, want %d", word, got, want)
+		}
+	}
+}
+
+func TestVocabularyCorrect(t *testing.T) {
+	v := &vocabulary{terms: map[string]string{}, freq: map[string]int{}}
+	for _, word := range []string{"aviator", "aviator", "polarized", "round", "rounded", "black", "block"} {
+		v.add(word, word)
+	}
+	v.add("rayban", "ray ban")
+
+	tests := []struct {
+		word   string
+		want   string
+		wantOK bool
+	}{
+		{"aviator", "aviator", true},
+		{"aviatro", "aviator", true},
+		{"polarised", "polarized", true},
+		{"raybna", "ray ban", true}, // joined brand words search for both
+		{"roun", "", false},         // prefix of a catalog word is left to the index
+		{"blick", "black", true},    // equally close: alphabetical when just as frequent
+		{"xyzzyq", "", false},
+		{"rb3025", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := v.correct(tt.word)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("correct(%q) = %q, %v; want %q, %v", tt.word, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}